@@ -1,10 +1,14 @@
 package downloader
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/elvis972602/kemono-scraper/export"
 	"github.com/elvis972602/kemono-scraper/kemono"
 	"github.com/elvis972602/kemono-scraper/utils"
 	"html/template"
@@ -12,7 +16,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,6 +25,8 @@ const (
 	maxConcurrent           = 5
 	maxConnection           = 100
 	rateLimit               = 2
+	apiRateLimit            = 1
+	maxRetryInterval        = 2 * time.Minute
 	UserAgent               = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
 	Accept                  = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
 	AcceptEncoding          = "gzip, deflate, br"
@@ -42,6 +48,39 @@ type Log interface {
 
 type Header map[string]string
 
+// State records downloaded files, so later runs can skip them without touching the filesystem
+type State interface {
+	// FileDone return where the file was saved, if it was downloaded before
+	FileDone(creator kemono.Creator, post kemono.Post, hash string) (path string, ok bool, err error)
+	// MarkFile record a downloaded file
+	MarkFile(creator kemono.Creator, post kemono.Post, file kemono.File, hash, path string, size int64) error
+}
+
+// errSizeOutOfRange the file is skipped by the max/min size option
+var errSizeOutOfRange = errors.New("size out of range")
+
+// statusError an unexpected http status
+type statusError struct {
+	code   int
+	header http.Header
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// IntegrityError the downloaded file does not match the sha256 in its kemono path
+type IntegrityError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity error: %s has sha256 %s, expected %s", e.Path, e.Actual, e.Expected)
+}
+
 type DownloadOption func(*downloader)
 
 type downloader struct {
@@ -59,12 +98,21 @@ type downloader struct {
 
 	minSize int64
 
+	// size limits of creators map[<service>:<id>]sizeRange, they replace maxSize and minSize
+	userSizes map[string]sizeRange
+
 	// SavePath return the path to save the file
 	SavePath func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string
 	// timeout
 	Timeout time.Duration
 
-	reteLimiter *utils.RateLimiter
+	// requests per second of file transfers, per host, and of api calls
+	fileRate float64
+	apiRate  float64
+	burst    int
+
+	fileLimiter *utils.HostLimiter
+	apiLimiter  *utils.HostLimiter
 
 	Header Header
 
@@ -74,8 +122,33 @@ type downloader struct {
 
 	retryInterval time.Duration
 
+	retryPolicy utils.RetryPolicy
+
 	content bool
 
+	// content of creators map[<service>:<id>]bool, it replaces content
+	userContent map[string]bool
+
+	// point the links of content.html to the save paths of the files
+	rewriteContent bool
+
+	// exporters writing every post once its files are downloaded
+	exporters []export.Exporter
+
+	// fetchers downloading the external links of posts
+	fetchers []kemono.ExternalFetcher
+
+	state State
+
+	// send a HEAD request for the size of planned files
+	headSize bool
+
+	// content addressed store, if set, files are downloaded once and linked to their save paths
+	store *Store
+
+	// worker pool shared by every Download call, at most MaxConcurrent files are downloaded at the same time
+	pool chan struct{}
+
 	progress *Progress
 
 	log Log
@@ -93,7 +166,10 @@ func NewDownloader(options ...DownloadOption) kemono.Downloader {
 		OverWrite:     false,
 		maxSize:       1<<63 - 1,
 		minSize:       0,
-		reteLimiter:   utils.NewRateLimiter(rateLimit),
+		userSizes:     make(map[string]sizeRange),
+		userContent:   make(map[string]bool),
+		fileRate:      rateLimit,
+		apiRate:       apiRateLimit,
 		retry:         2,
 		client: &http.Client{
 			Transport: &http.Transport{
@@ -117,6 +193,18 @@ func NewDownloader(options ...DownloadOption) kemono.Downloader {
 	if d.log == nil {
 		panic("log is nil")
 	}
+	d.pool = make(chan struct{}, d.MaxConcurrent)
+	if d.retryPolicy == nil {
+		d.retryPolicy = utils.ExponentialBackoff{
+			Base:          d.retryInterval,
+			Max:           maxRetryInterval,
+			Jitter:        0.2,
+			ConnRetries:   d.retry - 1,
+			StatusRetries: d.retry - 1,
+		}
+	}
+	d.fileLimiter = utils.NewHostLimiter(d.fileRate, d.burst)
+	d.apiLimiter = utils.NewHostLimiter(d.apiRate, d.burst)
 
 	d.progress = NewProgress(d.log)
 	d.progress.Run(100 * time.Millisecond)
@@ -124,10 +212,10 @@ func NewDownloader(options ...DownloadOption) kemono.Downloader {
 	return d
 }
 
-// BaseURL set the base url
+// BaseURL set the base url, it should be the same as the kemono base url
 func BaseURL(baseURL string) DownloadOption {
 	return func(d *downloader) {
-		d.BaseURL = baseURL
+		d.BaseURL = strings.TrimRight(baseURL, "/")
 	}
 }
 
@@ -152,6 +240,13 @@ func MinSize(minSize int64) DownloadOption {
 	}
 }
 
+// WithUserSizeLimit set the min and max size of the files of creator, they replace MinSize and MaxSize for it
+func WithUserSizeLimit(creator kemono.Creator, minSize, maxSize int64) DownloadOption {
+	return func(d *downloader) {
+		d.userSizes[creator.PairString()] = sizeRange{min: minSize, max: maxSize}
+	}
+}
+
 // Timeout set the timeout
 func Timeout(timeout time.Duration) DownloadOption {
 	return func(d *downloader) {
@@ -159,10 +254,24 @@ func Timeout(timeout time.Duration) DownloadOption {
 	}
 }
 
-// RateLimit limit the rate of download per second
-func RateLimit(n int) DownloadOption {
+// RateLimit limit the file transfers per second of each host, e.g. 0.5 for one every 2 seconds, <= 0 for no limit
+func RateLimit(rate float64) DownloadOption {
+	return func(d *downloader) {
+		d.fileRate = rate
+	}
+}
+
+// APIRateLimit limit the api requests per second, <= 0 for no limit
+func APIRateLimit(rate float64) DownloadOption {
+	return func(d *downloader) {
+		d.apiRate = rate
+	}
+}
+
+// RateBurst set how many requests may be sent at once before the rate limit applies, default is 1
+func RateBurst(burst int) DownloadOption {
 	return func(d *downloader) {
-		d.reteLimiter = utils.NewRateLimiter(n)
+		d.burst = burst
 	}
 }
 
@@ -178,6 +287,13 @@ func WithCookie(cookies []*http.Cookie) DownloadOption {
 	}
 }
 
+// WithTransport set the transport of the http client, e.g. to send requests to a test server
+func WithTransport(transport http.RoundTripper) DownloadOption {
+	return func(d *downloader) {
+		d.client.Transport = transport
+	}
+}
+
 func WithProxy(proxy string) DownloadOption {
 	return func(d *downloader) {
 		AddProxy(proxy, d.client.Transport.(*http.Transport))
@@ -231,39 +347,175 @@ func Retry(retry int) DownloadOption {
 	}
 }
 
+// RetryInterval set the first retry interval, it doubles after each failure
 func RetryInterval(interval time.Duration) DownloadOption {
 	return func(d *downloader) {
 		d.retryInterval = interval
 	}
 }
 
+// WithRetryPolicy set the retry policy, it replaces Retry and RetryInterval
+func WithRetryPolicy(policy utils.RetryPolicy) DownloadOption {
+	return func(d *downloader) {
+		d.retryPolicy = policy
+	}
+}
+
 func WithContent(content bool) DownloadOption {
 	return func(d *downloader) {
 		d.content = content
 	}
 }
 
+// WithUserContent set whether the content of the posts of creator is written, it replaces WithContent for it
+func WithUserContent(creator kemono.Creator, content bool) DownloadOption {
+	return func(d *downloader) {
+		d.userContent[creator.PairString()] = content
+	}
+}
+
+// WithRewriteContent point the images and links of content.html referring to files of the post to their save paths
+func WithRewriteContent(rewrite bool) DownloadOption {
+	return func(d *downloader) {
+		d.rewriteContent = rewrite
+	}
+}
+
+// WithExporter write every post with the exporters once its files are downloaded, into the directory of its
+// content. Exporters implementing io.Closer are closed with the downloader
+func WithExporter(exporters ...export.Exporter) DownloadOption {
+	return func(d *downloader) {
+		d.exporters = append(d.exporters, exporters...)
+	}
+}
+
+// WithExternalFetcher download the external links of every post with the first fetcher matching them, into the
+// directory of its content
+func WithExternalFetcher(fetchers ...kemono.ExternalFetcher) DownloadOption {
+	return func(d *downloader) {
+		d.fetchers = append(d.fetchers, fetchers...)
+	}
+}
+
+// WithStore keep every file once in store, keyed by its sha256, and link the save paths to it.
+// Files already in the store are never downloaded again
+func WithStore(store *Store) DownloadOption {
+	return func(d *downloader) {
+		d.store = store
+	}
+}
+
+// WithHeadSize get the size of each planned file with a HEAD request in dry-run mode
+func WithHeadSize(headSize bool) DownloadOption {
+	return func(d *downloader) {
+		d.headSize = headSize
+	}
+}
+
+// WithState skip the files recorded in state, and record the new ones
+func WithState(state State) DownloadOption {
+	return func(d *downloader) {
+		d.state = state
+	}
+}
+
 func (d *downloader) Get(url string) (resp *http.Response, err error) {
+	return d.GetContext(context.Background(), url)
+}
+
+func (d *downloader) GetContext(ctx context.Context, url string) (resp *http.Response, err error) {
 	var (
 		req *http.Request
 	)
-	if req, err = newGetRequest(context.Background(), d.Header, d.cookies, url); err != nil {
+	if req, err = newGetRequest(ctx, d.Header, d.cookies, url); err != nil {
 		return
 	}
-	return d.client.Do(req)
+	limiter := d.apiLimiter.Get(req.URL.Host)
+	if err = limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limit error: %w", err)
+	}
+	resp, err = d.client.Do(req)
+	if err == nil {
+		observe(limiter, resp)
+	}
+	return
+}
+
+// Close stop the rate limiters, requests waiting for them fail
+func (d *downloader) Close() error {
+	d.fileLimiter.Stop()
+	d.apiLimiter.Stop()
+	for _, e := range d.exporters {
+		if closer, ok := e.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return fmt.Errorf("close exporter error: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// observe pause the limiter after a 429 response, as long as its Retry-After asks,
+// and reset its backoff after any other
+func observe(limiter *utils.RateLimiter, resp *http.Response) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, ok := utils.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			limiter.Pause(retryAfter)
+		} else {
+			limiter.Backoff()
+		}
+	} else {
+		limiter.Recover()
+	}
+}
+
+// sizeRange the sizes of the files to download, others are skipped
+type sizeRange struct {
+	min, max int64
+}
+
+// contains report whether size is in the range, an unknown size (-1) is
+func (r sizeRange) contains(size int64) bool {
+	return size < 0 || (size >= r.min && size <= r.max)
+}
+
+// sizeRange return the size limits of the files of creator
+func (d *downloader) sizeRange(creator kemono.Creator) sizeRange {
+	if r, ok := d.userSizes[creator.PairString()]; ok {
+		return r
+	}
+	return sizeRange{min: d.minSize, max: d.maxSize}
+}
+
+// writeContent report whether the content of the posts of creator is written
+func (d *downloader) writeContent(creator kemono.Creator) bool {
+	if content, ok := d.userContent[creator.PairString()]; ok {
+		return content
+	}
+	return d.content
 }
 
 func (d *downloader) WriteContent(creator kemono.Creator, post kemono.Post, content string) error {
-	if !d.content {
+	if !d.writeContent(creator) {
 		return nil
 	}
-	path := d.SavePath(creator, post, 0, kemono.File{Path: "content.html", Name: "content.html"})
-	path = filepath.Join(filepath.Dir(path), "content.html")
+	path := filepath.Join(d.postDir(creator, post), "content.html")
 	err := os.MkdirAll(filepath.Dir(path), 0755)
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(path)
+	if d.rewriteContent {
+		// map[<remote path>]<save path relative to content.html>
+		files := make(map[string]string)
+		for _, f := range kemono.AddIndexToAttachments(post.Attachments) {
+			if rel, err := filepath.Rel(filepath.Dir(path), d.savePath(creator, post, f)); err == nil {
+				files[export.RemotePath(f.Path)] = filepath.ToSlash(rel)
+			}
+		}
+		if content, err = export.RewriteContent(content, files); err != nil {
+			return err
+		}
+	}
 	contentTemplate := `<!DOCTYPE html>
 <html>
 <head>
@@ -277,57 +529,245 @@ func (d *downloader) WriteContent(creator kemono.Creator, post kemono.Post, cont
 	if err != nil {
 		return err
 	}
-	return tmpl.Execute(file, struct {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create content file error: %w", err)
+	}
+	defer file.Close()
+	err = tmpl.Execute(file, struct {
 		Title   string
 		Content template.HTML
 	}{
 		Title:   post.Title,
 		Content: template.HTML(content),
 	})
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Export write the post with the exporters, files are the outcomes of its files
+func (d *downloader) Export(creator kemono.Creator, post kemono.Post, files []kemono.FileResult) error {
+	e := export.Entry{
+		Creator: creator,
+		Post:    post,
+		Dir:     d.postDir(creator, post),
+		Files:   files,
+	}
+	for _, exporter := range d.exporters {
+		if err := exporter.Export(e); err != nil {
+			return fmt.Errorf("export post error: %w", err)
+		}
+	}
+	return nil
+}
+
+// DownloadExternal download the external links of the post with the fetchers, the links no fetcher matches are
+// left. All links are tried, the first error is returned
+func (d *downloader) DownloadExternal(ctx context.Context, creator kemono.Creator, post kemono.Post) error {
+	if len(d.fetchers) == 0 {
+		return nil
+	}
+	var first error
+	for _, link := range kemono.ExternalLinks(post) {
+		for _, f := range d.fetchers {
+			if !f.Match(link) {
+				continue
+			}
+			if err := kemono.Cancelled(ctx); err != nil {
+				return err
+			}
+			d.log.Printf("download external link %s", link.URL)
+			if err := f.Fetch(ctx, link, d.postDir(creator, post)); err != nil && first == nil {
+				first = err
+			}
+			break
+		}
+	}
+	return first
 }
 
+// postDir the directory of the post, where its content is written
+func (d *downloader) postDir(creator kemono.Creator, post kemono.Post) string {
+	return filepath.Dir(d.SavePath(creator, post, 0, kemono.File{Path: "content.html", Name: "content.html"}))
+}
+
+// Download download files until the caller closes files.
+// The returned channel yields the errors of the failed files, and is closed when all files are done
 func (d *downloader) Download(files <-chan kemono.FileWithIndex, creator kemono.Creator, post kemono.Post) <-chan error {
+	results := d.DownloadContext(context.Background(), files, creator, post)
+	errCh := make(chan error, d.MaxConcurrent)
+	go func() {
+		defer close(errCh)
+		for r := range results {
+			if r.Status == kemono.FileFailed {
+				errCh <- r.Err
+			}
+		}
+	}()
+	return errCh
+}
+
+// DownloadContext download files with MaxConcurrent workers until the caller closes files.
+// The returned channel yields the outcome of every file and is closed when all files are done,
+// the caller must drain it. When ctx is done the remaining files are reported as cancelled,
+// and the files in progress keep their partial <file>.tmp, resumed by the next download
+func (d *downloader) DownloadContext(ctx context.Context, files <-chan kemono.FileWithIndex, creator kemono.Creator, post kemono.Post) <-chan kemono.FileResult {
 	var (
 		wg    sync.WaitGroup
-		errCh = make(chan error, len(files))
+		resCh = make(chan kemono.FileResult, d.MaxConcurrent)
 	)
 
 	for i := 0; i < d.MaxConcurrent; i++ {
 		wg.Add(1)
 		go func() {
-			for {
-				select {
-
-				case file, ok := <-files:
-					// download file
-					if ok {
-						url := d.BaseURL + file.GetURL()
-						hash, err := file.GetHash()
-						if err != nil {
-							hash = ""
-						}
-						savePath := d.SavePath(creator, post, file.Index, file.File)
-
-						if err := d.download(savePath, url, hash); err != nil {
-							errCh <- err
-							continue
-						}
-					}
-				default:
-					if len(files) == 0 {
-						wg.Done()
-						return
-					}
-				}
+			defer wg.Done()
+			for file := range files {
+				resCh <- d.downloadAttachment(ctx, creator, post, file)
 			}
 		}()
 	}
-	wg.Wait()
-	return errCh
+	go func() {
+		wg.Wait()
+		close(resCh)
+	}()
+	return resCh
 }
 
-// download downloads the file from the url
-func (d *downloader) download(filePath, url, fileHash string) error {
+// downloadAttachment download a file of the post, and return its outcome
+func (d *downloader) downloadAttachment(ctx context.Context, creator kemono.Creator, post kemono.Post, file kemono.FileWithIndex) kemono.FileResult {
+	url := d.BaseURL + file.GetURL()
+	hash := fileHash(file)
+	savePath := d.savePath(creator, post, file)
+	result := kemono.NewFileResult(file, savePath, kemono.FileDownloaded)
+	if err := kemono.Cancelled(ctx); err != nil {
+		return result.Failed(err)
+	}
+
+	if d.stateDone(creator, post, hash, savePath) {
+		d.log.Printf("file %s already downloaded, skip", savePath)
+		result.Status = kemono.FileExists
+		return result
+	}
+
+	// wait for a slot of the shared pool
+	select {
+	case d.pool <- struct{}{}:
+	case <-ctx.Done():
+		return result.Failed(kemono.Cancelled(ctx))
+	}
+	result = d.download(ctx, result, url, hash, d.sizeRange(creator))
+	<-d.pool
+
+	if d.state != nil && hash != "" && (result.Status == kemono.FileDownloaded || result.Status == kemono.FileExists || result.Status == kemono.FileLinked) {
+		if err := d.markFile(creator, post, file.File, hash, savePath); err != nil {
+			d.log.Printf("record file state error: %s", err)
+		}
+	}
+	return result
+}
+
+// PlanContext resolve the save path of the file, and whether it would be downloaded, without downloading it
+func (d *downloader) PlanContext(ctx context.Context, creator kemono.Creator, post kemono.Post, file kemono.FileWithIndex) kemono.PlannedFile {
+	hash := fileHash(file)
+	planned := kemono.PlannedFile{
+		Index:    file.Index,
+		Name:     file.Name,
+		Path:     file.Path,
+		SavePath: d.savePath(creator, post, file),
+		Status:   kemono.FilePlanned,
+		Size:     -1,
+	}
+	if !d.OverWrite {
+		if d.stateDone(creator, post, hash, planned.SavePath) {
+			planned.Status = kemono.FileExists
+			return planned
+		}
+		if complete, err := checkFileExitAndComplete(planned.SavePath, hash); err == nil && complete {
+			planned.Status = kemono.FileExists
+			return planned
+		}
+		if d.store != nil && hash != "" && d.store.Has(hash) {
+			planned.Status = kemono.FileLinked
+			return planned
+		}
+	}
+	if d.headSize {
+		size, err := d.head(ctx, d.BaseURL+file.GetURL())
+		if err != nil {
+			planned.Error = err.Error()
+			return planned
+		}
+		planned.Size = size
+		if !d.sizeRange(creator).contains(size) {
+			planned.Status = kemono.FileSkippedSize
+		}
+	}
+	return planned
+}
+
+// head return the Content-Length of url, -1 if unknown
+func (d *downloader) head(ctx context.Context, url string) (int64, error) {
+	req, err := newGetRequest(ctx, d.Header, d.cookies, url)
+	if err != nil {
+		return -1, err
+	}
+	req.Method = http.MethodHead
+	limiter := d.fileLimiter.Get(req.URL.Host)
+	if err = limiter.Wait(ctx); err != nil {
+		return -1, fmt.Errorf("rate limit error: %w", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("head request error: %w", err)
+	}
+	resp.Body.Close()
+	observe(limiter, resp)
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("head request error: %s", resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// savePath return where the file is saved, the target of a retried file or the SavePath option
+func (d *downloader) savePath(creator kemono.Creator, post kemono.Post, file kemono.FileWithIndex) string {
+	if file.Target != "" {
+		return file.Target
+	}
+	return d.SavePath(creator, post, file.Index, file.File)
+}
+
+// stateDone report whether the state records the file at savePath
+func (d *downloader) stateDone(creator kemono.Creator, post kemono.Post, hash, savePath string) bool {
+	if d.state == nil || d.OverWrite || hash == "" {
+		return false
+	}
+	path, ok, err := d.state.FileDone(creator, post, hash)
+	if err != nil {
+		d.log.Printf("check file state error: %s", err)
+		return false
+	}
+	return ok && path == savePath
+}
+
+// fileHash return the sha256 in the kemono path of the file, empty if the name of the file is not one:
+// the content is then not checked, and not keyed by its hash in the state or the store
+func fileHash(file kemono.FileWithIndex) string {
+	hash, err := file.GetHash()
+	if err != nil || len(hash) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return ""
+	}
+	return strings.ToLower(hash)
+}
+
+// download downloads the file from the url, and fill the outcome into result.
+// A file whose size is out of sizes is skipped
+func (d *downloader) download(ctx context.Context, result kemono.FileResult, url, fileHash string, sizes sizeRange) kemono.FileResult {
+	filePath := result.SavePath
 	// check if the file exists
 	var (
 		complete bool
@@ -337,95 +777,218 @@ func (d *downloader) download(filePath, url, fileHash string) error {
 		complete, err = checkFileExitAndComplete(filePath, fileHash)
 		if err != nil {
 			err = errors.New("check file error: " + err.Error())
-			return err
+			return result.Failed(err)
 		}
 		if complete {
 			d.log.Printf("file %s already exists, skip", filePath)
-			return nil
+			result.Status = kemono.FileExists
+			return result
 		}
 	}
 
+	if d.store != nil && fileHash != "" {
+		return d.downloadToStore(ctx, result, url, fileHash, sizes)
+	}
+
 	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 	if err != nil {
 		err = errors.New("create directory error: " + err.Error())
-		return err
+		return result.Failed(err)
 	}
 	// download the file
-	if err := d.downloadFile(filePath, url); err != nil {
-		//err = errors.New("download file error: " + err.Error())
-		return err
-	}
-	time.Sleep(1 * time.Second)
-	return nil
+	n, err := d.downloadFile(ctx, filePath, url, fileHash, sizes)
+	return downloadResult(result, n, err)
 }
 
-// download the file from the url, and save to the file
-func (d *downloader) downloadFile(filePath, url string) error {
-	d.reteLimiter.Token()
+// downloadToStore download the file into the store unless it is there, and link the save path to it
+func (d *downloader) downloadToStore(ctx context.Context, result kemono.FileResult, url, fileHash string, sizes sizeRange) kemono.FileResult {
+	unlock := d.store.Lock(fileHash)
+	defer unlock()
 
-	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
-	defer cancel()
+	if !d.OverWrite && d.store.Has(fileHash) {
+		d.log.Printf("file %s already in store, link", result.SavePath)
+		result.Status = kemono.FileLinked
+	} else {
+		blob := d.store.Path(fileHash)
+		if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+			return result.Failed(errors.New("create directory error: " + err.Error()))
+		}
+		n, err := d.downloadFile(ctx, blob, url, fileHash, sizes)
+		result = downloadResult(result, n, err)
+		if result.Status != kemono.FileDownloaded {
+			return result
+		}
+	}
+	if err := d.store.Link(fileHash, result.SavePath); err != nil {
+		return result.Failed(fmt.Errorf("link file error: %w", err))
+	}
+	return result
+}
 
-	req, err := newGetRequest(ctx, d.Header, d.cookies, url)
+// downloadResult fill the outcome of downloadFile into result
+func downloadResult(result kemono.FileResult, n int64, err error) kemono.FileResult {
+	result.Bytes = n
+	var se *statusError
+	if errors.As(err, &se) {
+		result.StatusCode = se.code
+	} else if err == nil {
+		result.StatusCode = http.StatusOK
+	}
+	if err == errSizeOutOfRange {
+		result.Status = kemono.FileSkippedSize
+		return result
+	}
 	if err != nil {
-		return fmt.Errorf("new request error: %w", err)
+		//err = errors.New("download file error: " + err.Error())
+		return result.Failed(err)
+	}
+	return result
+}
+
+// download the file from the url, and save to the file.
+// A partial <file>.tmp left by a failed attempt is resumed with a Range request
+// It returns the bytes transferred over all attempts
+func (d *downloader) downloadFile(parent context.Context, filePath, url, fileHash string, sizes sizeRange) (int64, error) {
+	if err := kemono.Cancelled(parent); err != nil {
+		return 0, err
 	}
 
+	tmpFilePath := filePath + ".tmp"
+	var written int64
+
 	var get func() error
 
 	get = func() error {
+		ctx, cancel := context.WithTimeout(parent, d.Timeout)
+		defer cancel()
+
+		req, err := newGetRequest(ctx, d.Header, d.cookies, url)
+		if err != nil {
+			return fmt.Errorf("new request error: %w", err)
+		}
+
+		// resume the partial file if there is one
+		offset := partialSize(tmpFilePath)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			// a compressed range can not be appended to the partial file
+			req.Header.Set("Accept-Encoding", "identity")
+			if validator := readValidator(tmpFilePath); validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
 		bar := NewProgressBar(fmt.Sprintf("%s", filepath.Base(filePath)), 0, 30)
 		d.progress.AddBar(bar)
 		defer func() {
-			if !bar.IsDone() {
+			if bar.IsDone() {
+				return
+			}
+			if parent.Err() != nil {
+				d.progress.Cancel(bar, "cancelled")
+			} else {
 				d.progress.Failed(bar, fmt.Errorf("download failed"))
 			}
 		}()
 
+		limiter := d.fileLimiter.Get(req.URL.Host)
+		if err = limiter.Wait(parent); err != nil {
+			if e := kemono.Cancelled(parent); e != nil {
+				return e
+			}
+			return fmt.Errorf("rate limit error: %w", err)
+		}
 		resp, err := d.client.Do(req)
 		if err != nil {
 			return fmt.Errorf("failed to make request: %w", err)
 		}
 		defer resp.Body.Close()
-
-		// get content length
-		contentLength, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-		if err != nil {
-			return fmt.Errorf("failed to get content length: %w", err)
-		}
-		bar.Max = contentLength
-
-		if contentLength > d.maxSize || contentLength < d.minSize {
-			d.progress.Cancel(bar, "size out of range")
-			return nil
-		}
+		observe(limiter, resp)
 
 		// 429 too many requests
 		if resp.StatusCode == http.StatusTooManyRequests {
 			d.progress.Failed(bar, fmt.Errorf("http 429"))
-			return fmt.Errorf("request too many times")
+			return &statusError{code: resp.StatusCode, header: resp.Header, err: fmt.Errorf("request too many times")}
 		}
 
-		if resp.StatusCode != http.StatusOK {
+		var total int64
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err != nil || start != offset {
+				// can not append to the partial file, start over on the next attempt
+				removePartial(tmpFilePath)
+				d.progress.Failed(bar, fmt.Errorf("invalid content range"))
+				return fmt.Errorf("invalid content range: %q", resp.Header.Get("Content-Range"))
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+				// the range is compressed anyway, start over with a full download on the next attempt
+				removePartial(tmpFilePath)
+				d.progress.Failed(bar, fmt.Errorf("compressed content range"))
+				return fmt.Errorf("content range encoded with %s", enc)
+			}
+			total = size
+		case http.StatusOK:
+			// the server ignored the range, download the whole file.
+			// the size is -1 if the server sent no Content-Length
+			offset = 0
+			total = resp.ContentLength
+		case http.StatusRequestedRangeNotSatisfiable:
+			removePartial(tmpFilePath)
 			d.progress.Failed(bar, fmt.Errorf("http %d", resp.StatusCode))
-			return fmt.Errorf("failed to download file: %d", resp.StatusCode)
+			return &statusError{code: resp.StatusCode, header: resp.Header, err: fmt.Errorf("failed to resume file: %d", resp.StatusCode)}
+		default:
+			d.progress.Failed(bar, fmt.Errorf("http %d", resp.StatusCode))
+			return &statusError{code: resp.StatusCode, header: resp.Header, err: fmt.Errorf("failed to download file: %d", resp.StatusCode)}
+		}
+		bar.SetMax(total)
+
+		if !sizes.contains(total) {
+			d.progress.Cancel(bar, "size out of range")
+			return errSizeOutOfRange
 		}
 
-		tmpFilePath := filePath + ".tmp"
-		tmpFile, err := os.Create(tmpFilePath)
+		var tmpFile *os.File
+		if offset > 0 {
+			tmpFile, err = os.OpenFile(tmpFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+		} else {
+			tmpFile, err = os.Create(tmpFilePath)
+			if err == nil {
+				writeValidator(tmpFilePath, resp.Header)
+			}
+		}
 		if err != nil {
-			// delete the tmp file
-			_ = os.Remove(tmpFilePath)
 			return fmt.Errorf("create tmp file error: %w", err)
 		}
+		defer tmpFile.Close()
+		bar.Set64(offset)
+
+		// hash the body as it streams, after the part downloaded before
+		hash := sha256.New()
+		if offset > 0 {
+			if err = hashFile(hash, tmpFilePath, offset); err != nil {
+				removePartial(tmpFilePath)
+				return fmt.Errorf("hash partial file error: %w", err)
+			}
+		}
+		body := io.Reader(resp.Body)
+		if resp.Header.Get("Content-Encoding") == "gzip" && !resp.Uncompressed {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return fmt.Errorf("gzip reader error: %w", err)
+			}
+			defer gz.Close()
+			body = gz
+		}
 
-		defer func() {
-			_ = tmpFile.Close()
-			_ = os.Remove(tmpFilePath)
-		}()
-
-		_, err = io.Copy(io.MultiWriter(tmpFile, bar), resp.Body)
+		// the partial file is kept on failure, so the next attempt can resume it
+		n, err := io.Copy(io.MultiWriter(tmpFile, bar, hash), body)
+		written += n
 		if err != nil {
+			if cerr := kemono.Cancelled(parent); cerr != nil {
+				d.progress.Cancel(bar, "cancelled")
+				return cerr
+			}
 			d.progress.Failed(bar, err)
 			return fmt.Errorf("io copy error: %w", err)
 		}
@@ -435,28 +998,139 @@ func (d *downloader) downloadFile(filePath, url string) error {
 			return fmt.Errorf("close tmp file error: %w", err)
 		}
 
+		if actual := hex.EncodeToString(hash.Sum(nil)); fileHash != "" && !strings.EqualFold(actual, fileHash) {
+			// corrupted, start over on the next attempt
+			removePartial(tmpFilePath)
+			d.progress.Failed(bar, fmt.Errorf("hash mismatch"))
+			return &IntegrityError{Path: filePath, Expected: fileHash, Actual: actual}
+		}
+
 		// rename the tmp file to the file
 		err = os.Rename(tmpFilePath, filePath)
 		if err != nil {
 			return fmt.Errorf("rename file error: %w", err)
 		}
+		_ = os.Remove(validatorPath(tmpFilePath))
 
 		d.progress.Success(bar)
 		return nil
 	}
 
-	for i := 0; i < d.retry; i++ {
-		err = get()
-		if err == nil {
-			return nil
+	var attempt utils.Attempt
+	for {
+		err := get()
+		if err == nil || err == errSizeOutOfRange {
+			return written, err
+		}
+		if cerr := kemono.Cancelled(parent); cerr != nil {
+			return written, cerr
+		}
+		attempt.Err = err
+		var se *statusError
+		if errors.As(err, &se) {
+			attempt.StatusCode, attempt.Header = se.code, se.header
+			attempt.StatusFailures++
+		} else {
+			attempt.StatusCode, attempt.Header = 0, nil
+			attempt.ConnFailures++
+		}
+		delay, ok := d.retryPolicy.Retry(attempt)
+		if !ok {
+			return written, fmt.Errorf("failed to download file: %w", err)
+		}
+		d.log.Printf("download failed: %s, retry after %.1f seconds...", err.Error(), delay.Seconds())
+		if cerr := sleepContext(parent, delay); cerr != nil {
+			return written, cerr
 		}
-		d.log.Printf("download failed: %s, retry after %.1f seconds...", err.Error(), d.retryInterval.Seconds())
-		time.Sleep(d.retryInterval)
 	}
-	return fmt.Errorf("failed to download file: %w", err)
 
 }
 
+// hashFile write the first n bytes of the file into hash
+func hashFile(hash io.Writer, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(hash, f, n)
+	return err
+}
+
+// partialSize return the size of the partial file, 0 if it does not exist
+func partialSize(tmpFilePath string) int64 {
+	f, err := os.Stat(tmpFilePath)
+	if err != nil || f.IsDir() {
+		return 0
+	}
+	return f.Size()
+}
+
+// removePartial delete the partial file and its validator
+func removePartial(tmpFilePath string) {
+	_ = os.Remove(tmpFilePath)
+	_ = os.Remove(validatorPath(tmpFilePath))
+}
+
+// validatorPath the file keeping the ETag or Last-Modified of the partial file, used by If-Range
+func validatorPath(tmpFilePath string) string {
+	return tmpFilePath + ".validator"
+}
+
+func readValidator(tmpFilePath string) string {
+	b, err := os.ReadFile(validatorPath(tmpFilePath))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func writeValidator(tmpFilePath string, header http.Header) {
+	validator := header.Get("ETag")
+	// weak ETags can not be used with If-Range
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		_ = os.Remove(validatorPath(tmpFilePath))
+		return
+	}
+	_ = os.WriteFile(validatorPath(tmpFilePath), []byte(validator), 0644)
+}
+
+// parseContentRange parse "bytes <start>-<end>/<size>", return the start and the full size
+func parseContentRange(contentRange string) (start, size int64, err error) {
+	var end int64
+	_, err = fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse content range error: %w", err)
+	}
+	if start > end || end >= size {
+		return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+	}
+	return
+}
+
+// sleepContext sleep for d, return a *kemono.CancelledError if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return kemono.Cancelled(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
+func (d *downloader) markFile(creator kemono.Creator, post kemono.Post, file kemono.File, hash, path string) error {
+	f, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return d.state.MarkFile(creator, post, file, hash, path, f.Size())
+}
+
 // check if the file exists, if exists, check if the file is complete,and return the file
 // if the file is complete, return true
 func checkFileExitAndComplete(filePath, fileHash string) (complete bool, err error) {
@@ -508,5 +1182,9 @@ func newGetRequest(ctx context.Context, header Header, cookies []*http.Cookie, u
 }
 
 func DirectoryName(p kemono.Post) string {
+	if p.Published.IsZero() {
+		// no date rather than 00010101
+		return fmt.Sprintf("[%s] %s", p.Id, p.Title)
+	}
 	return fmt.Sprintf("[%s] [%s] %s", p.Published.Format("20060102"), p.Id, p.Title)
 }
@@ -0,0 +1,347 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+type nopLog struct{}
+
+func (nopLog) Printf(format string, v ...interface{}) {}
+func (nopLog) Print(s string)                         {}
+func (nopLog) SetStatus(s []string)                   {}
+
+func newTestDownloader(t *testing.T, options ...DownloadOption) *downloader {
+	t.Helper()
+	options = append([]DownloadOption{BaseURL("http://localhost"), SetLog(nopLog{}), RateLimit(100), APIRateLimit(100)}, options...)
+	return NewDownloader(options...).(*downloader)
+}
+
+func TestDownloadFile_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("kemono-scraper"), 4096)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var ranges, encodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "file", modTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	half := int64(len(data) / 2)
+	if err := os.WriteFile(path+".tmp", data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(validatorPath(path+".tmp"), []byte(`"abc"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.downloadFile(context.Background(), path, srv.URL, hash, d.sizeRange(kemono.Creator{})); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if len(ranges) != 1 || ranges[0] != fmt.Sprintf("bytes=%d-", half) {
+		t.Fatalf("unexpected range requests: %v", ranges)
+	}
+	if encodings[0] != "identity" {
+		t.Errorf("a range request should not accept compression, got %q", encodings[0])
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed file differs from source")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("tmp file should be removed")
+	}
+}
+
+func TestDownloadFile_ResumeFallbackToFull(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ignore the Range header
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path+".tmp", []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.downloadFile(context.Background(), path, srv.URL, hash, d.sizeRange(kemono.Creator{})); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file differs from source")
+	}
+}
+
+func TestDownloadFile_ResumeCompressed(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.Header.Get("Range") != "" {
+			// compress the range whatever the client accepts
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, _ = gz.Write(data[5000:])
+			_ = gz.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 5000-%d/%d", len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(buf.Bytes())
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, RetryInterval(0))
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path+".tmp", data[:5000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.downloadFile(context.Background(), path, srv.URL, hash, d.sizeRange(kemono.Creator{})); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if len(ranges) != 2 || ranges[1] != "" {
+		t.Fatalf("expected a full download after the compressed range, got %v", ranges)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file differs from source")
+	}
+}
+
+func TestDownload_ClosedChannels(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := newTestDownloader(t,
+		BaseURL(srv.URL),
+		Async(true),
+		MaxConcurrent(3),
+		Retry(1),
+		SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, attachment.Name)
+		}),
+	)
+
+	files := make(chan kemono.FileWithIndex)
+	errCh := d.Download(files, kemono.Creator{}, kemono.Post{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			files <- kemono.File{Name: fmt.Sprintf("%d.png", i), Path: fmt.Sprintf("/%d.png", i)}.Index(i)
+		}
+		files <- kemono.File{Name: "missing.png", Path: "/missing.png"}.Index(5)
+		close(files)
+	}()
+
+	var errs []error
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%d.png", i))); err != nil {
+			t.Errorf("file %d not downloaded: %s", i, err)
+		}
+	}
+}
+
+func TestDownloadFile_Integrity(t *testing.T) {
+	data := bytes.Repeat([]byte("kemono-scraper"), 1000)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body := append([]byte(nil), data...)
+		if requests == 1 {
+			// corrupted once, with the right length
+			body[10] ^= 0xff
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, Retry(2))
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if _, err := d.downloadFile(context.Background(), path, srv.URL, hash, d.sizeRange(kemono.Creator{})); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("file differs from source")
+	}
+
+	// a file that never matches is not renamed into place
+	other := filepath.Join(t.TempDir(), "other.bin")
+	_, err = d.downloadFile(context.Background(), other, srv.URL, fmt.Sprintf("%x", sha256.Sum256([]byte("other"))), d.sizeRange(kemono.Creator{}))
+	var ie *IntegrityError
+	if !errors.As(err, &ie) {
+		t.Fatalf("expected an integrity error, got %v", err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Fatalf("corrupted file should not be saved")
+	}
+}
+
+func TestStore_Link(t *testing.T) {
+	data := []byte("blob")
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	for _, mode := range []LinkMode{LinkHard, LinkSymlink, LinkReflink} {
+		dir := t.TempDir()
+		store, err := NewStore(filepath.Join(dir, "store"), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(store.Path(hash)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(store.Path(hash), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(dir, "a", "file.bin")
+		// linking twice replaces nothing and does not fail
+		for i := 0; i < 2; i++ {
+			if err := store.Link(hash, target); err != nil {
+				t.Fatalf("%s: link failed: %s", mode, err)
+			}
+		}
+		got, err := os.ReadFile(target)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: linked file differs: %v", mode, err)
+		}
+	}
+}
+
+func TestDownloadFile_UserSizeLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("kemono-scraper"), 100)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	small := kemono.NewCreator("fanbox", "1")
+	d := newTestDownloader(t, MaxSize(int64(len(data)-1)), WithUserSizeLimit(small, 0, 1<<20))
+	dir := t.TempDir()
+	if _, err := d.downloadFile(context.Background(), filepath.Join(dir, "a"), srv.URL, hash, d.sizeRange(kemono.Creator{})); err != errSizeOutOfRange {
+		t.Fatalf("expected the size limit to skip the file, got %v", err)
+	}
+	if _, err := d.downloadFile(context.Background(), filepath.Join(dir, "b"), srv.URL, hash, d.sizeRange(small)); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+}
+
+func TestDownload_NotHashName(t *testing.T) {
+	data := []byte("not named by its hash")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "store"), LinkHard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDownloader(t, BaseURL(srv.URL), WithStore(store), SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+		return filepath.Join(dir, attachment.Name)
+	}))
+	file := kemono.File{Name: "cover.png", Path: "/ab/cd/cover.png"}
+	if hash := fileHash(file.Index(0)); hash != "" {
+		t.Fatalf("expected no hash for %s, got %s", file.Path, hash)
+	}
+
+	files := make(chan kemono.FileWithIndex, 1)
+	files <- file.Index(0)
+	close(files)
+	for r := range d.DownloadContext(context.Background(), files, kemono.Creator{}, kemono.Post{}) {
+		if r.Status != kemono.FileDownloaded {
+			t.Fatalf("expected the file to be downloaded, got %s: %v", r.Status, r.Err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "cover.png")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("file differs from source: %v", err)
+	}
+	if store.Has("cover") {
+		t.Errorf("the file should not be stored under its name")
+	}
+}
+
+func TestWriteContent_Rewrite(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(t, WithContent(true), WithRewriteContent(true),
+		SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, post.Id, attachment.Name)
+		}),
+	)
+	post := kemono.Post{
+		Id:          "1",
+		Title:       "post",
+		Attachments: []kemono.File{{Name: "b.png", Path: "/ab/cd/hash.png"}},
+	}
+	content := `<p><img src="https://kemono.su/data/ab/cd/hash.png"><a href="https://example.com/">site</a></p>`
+	if err := d.WriteContent(kemono.Creator{}, post, content); err != nil {
+		t.Fatalf("write content failed: %s", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "1", "content.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte(`<img src="b.png"/>`)) || !bytes.Contains(got, []byte(`<a href="https://example.com/">site</a>`)) {
+		t.Errorf("unexpected content:\n%s", got)
+	}
+}
@@ -27,17 +27,24 @@ const (
 	BarModeSuccess  = "Success"
 )
 
+// progressBar the progress of a download, max, cur and done are written by the download and read by the
+// status printer, so they are accessed atomically
 type progressBar struct {
 	Start   time.Time
 	Content string
-	Max     int64
+	max     int64
 	cur     int64
 	Length  int
-	done    bool
+	done    int32
 }
 
 func NewProgressBar(content string, max int64, length int) *progressBar {
-	return &progressBar{Start: time.Now(), Content: content, Max: max, Length: length}
+	return &progressBar{Start: time.Now(), Content: content, max: max, Length: length}
+}
+
+// SetMax set the total size of the download
+func (p *progressBar) SetMax(max int64) {
+	atomic.StoreInt64(&p.max, max)
 }
 
 func (p *progressBar) Add(n int) {
@@ -59,25 +66,26 @@ func (p *progressBar) Set64(n int64) {
 func (p *progressBar) String(mode string) string {
 	//var process string
 	var pre float64
-	if p.Max == 0 {
+	max, cur := atomic.LoadInt64(&p.max), atomic.LoadInt64(&p.cur)
+	if max <= 0 {
 		pre = 0
 
 	} else {
-		pre = float64(p.cur) / float64(p.Max)
+		pre = float64(cur) / float64(max)
 	}
-	speed := int64(float64(p.cur) / time.Since(p.Start).Seconds())
+	speed := int64(float64(cur) / time.Since(p.Start).Seconds())
 	if speed < 0 {
 		speed = 0
 	}
-	return buildProgressBar(utils.FormatDuration(int64(time.Since(p.Start))), mode, utils.FormatSize(speed), utils.FormatSize(p.Max), p.Content, pre, 30, mode)
+	return buildProgressBar(utils.FormatDuration(int64(time.Since(p.Start))), mode, utils.FormatSize(speed), utils.FormatSize(max), p.Content, pre, 30, mode)
 }
 
 func (p *progressBar) Done() {
-	p.done = true
+	atomic.StoreInt32(&p.done, 1)
 }
 
 func (p *progressBar) IsDone() bool {
-	return p.done
+	return atomic.LoadInt32(&p.done) == 1
 }
 
 func (p *progressBar) Write(b []byte) (n int, err error) {
@@ -0,0 +1,15 @@
+//go:build darwin
+
+package downloader
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// reflink clone src to dst with clonefile, on apfs
+func reflink(src, dst string) error {
+	if err := unix.Clonefile(src, dst, 0); err != nil {
+		return copyFile(src, dst)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package downloader
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clone src to dst with FICLONE, e.g. on btrfs or xfs
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return copyFile(src, dst)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package downloader
+
+// reflink the filesystem can not clone here, copy the file
+func reflink(src, dst string) error {
+	return copyFile(src, dst)
+}
@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LinkMode how a save path refers to the blob in the store
+type LinkMode string
+
+const (
+	// LinkHard hardlink the save path to the blob, both must be on the same filesystem
+	LinkHard LinkMode = "hardlink"
+	// LinkSymlink symlink the save path to the absolute path of the blob
+	LinkSymlink LinkMode = "symlink"
+	// LinkReflink copy-on-write clone of the blob, falls back to a plain copy if the filesystem can not clone
+	LinkReflink LinkMode = "reflink"
+)
+
+// ParseLinkMode parse hardlink, symlink or reflink
+func ParseLinkMode(s string) (LinkMode, error) {
+	switch m := LinkMode(strings.ToLower(s)); m {
+	case LinkHard, LinkSymlink, LinkReflink:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid link mode %q, must be hardlink, symlink or reflink", s)
+}
+
+// Store a content addressed blob store, every file is kept once at <root>/<hash[0:2]>/<hash[2:4]>/<hash>,
+// and the save paths are links to it
+type Store struct {
+	root string
+	mode LinkMode
+
+	lock sync.Mutex
+	// hashes being written, map[hash]*hashLock
+	locks map[string]*hashLock
+}
+
+type hashLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewStore create a store in root
+func NewStore(root string, mode LinkMode) (*Store, error) {
+	if _, err := ParseLinkMode(string(mode)); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("store path error: %w", err)
+	}
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("create store error: %w", err)
+	}
+	return &Store{root: root, mode: mode, locks: make(map[string]*hashLock)}, nil
+}
+
+// Path return the path of the blob
+func (s *Store) Path(hash string) string {
+	hash = strings.ToLower(hash)
+	if len(hash) < 4 {
+		return filepath.Join(s.root, hash)
+	}
+	return filepath.Join(s.root, hash[0:2], hash[2:4], hash)
+}
+
+// Has report whether the blob is in the store, only verified files are put into it
+func (s *Store) Has(hash string) bool {
+	f, err := os.Stat(s.Path(hash))
+	return err == nil && f.Mode().IsRegular()
+}
+
+// Lock lock the blob, so the same file is not downloaded twice at the same time
+func (s *Store) Lock(hash string) (unlock func()) {
+	s.lock.Lock()
+	l, ok := s.locks[hash]
+	if !ok {
+		l = &hashLock{}
+		s.locks[hash] = l
+	}
+	l.refs++
+	s.lock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.lock.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, hash)
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Link make target refer to the blob, replacing whatever is at target
+func (s *Store) Link(hash, target string) error {
+	blob := s.Path(hash)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if linked(blob, target) {
+		return nil
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	switch s.mode {
+	case LinkSymlink:
+		return os.Symlink(blob, target)
+	case LinkReflink:
+		return reflink(blob, target)
+	default:
+		return os.Link(blob, target)
+	}
+}
+
+// linked report whether target already is the blob, or a symlink to it
+func linked(blob, target string) bool {
+	t, err := os.Lstat(target)
+	if err != nil {
+		return false
+	}
+	if t.Mode()&os.ModeSymlink != 0 {
+		dest, err := os.Readlink(target)
+		return err == nil && dest == blob
+	}
+	b, err := os.Stat(blob)
+	return err == nil && os.SameFile(b, t)
+}
+
+// copyFile copy src to dst, used when the filesystem can not clone
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
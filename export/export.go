@@ -0,0 +1,177 @@
+// Package export writes the downloaded posts in formats readable without the site: post.json sidecars with
+// the full metadata, Markdown, HTML pages showing the local files, and an index.html browsing the posts of a creator.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+const (
+	// SidecarName the file name of the json sidecar of a post
+	SidecarName = "post.json"
+	// MarkdownName the file name of the Markdown of a post
+	MarkdownName = "post.md"
+	// HTMLName the file name of the HTML page of a post
+	HTMLName = "post.html"
+	// IndexName the file name of the index of a creator
+	IndexName = "index.html"
+)
+
+// Entry a post and the outcome of its files, exported into Dir
+type Entry struct {
+	Creator kemono.Creator
+	Post    kemono.Post
+	// Dir the directory of the post
+	Dir   string
+	Files []kemono.FileResult
+}
+
+// Exporter write an entry into its directory
+type Exporter interface {
+	Export(e Entry) error
+}
+
+// New return the exporter of a format: json, markdown, html, index or links
+func New(format string) (Exporter, error) {
+	switch format {
+	case "json":
+		return JSON(), nil
+	case "markdown":
+		return Markdown(), nil
+	case "html":
+		return HTML(), nil
+	case "index":
+		return NewIndex(), nil
+	case "links":
+		return Links(), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %s, must be json, markdown, html, index or links", format)
+	}
+}
+
+// Sidecar the content of post.json
+type Sidecar struct {
+	Creator kemono.Creator `json:"creator"`
+	Post    kemono.Post    `json:"post"`
+	Files   []SidecarFile  `json:"files"`
+}
+
+// SidecarFile a file of the post and where it was saved
+type SidecarFile struct {
+	Name   string            `json:"name"`
+	Remote string            `json:"remote"`
+	Status kemono.FileStatus `json:"status"`
+	// Local the path relative to the post directory, empty if the file was not saved
+	Local string `json:"local,omitempty"`
+}
+
+type jsonExporter struct{}
+
+// JSON write the post.json sidecar of the post, with its full metadata and the local path of its files
+func JSON() Exporter {
+	return jsonExporter{}
+}
+
+func (jsonExporter) Export(e Entry) error {
+	sidecar := Sidecar{
+		Creator: e.Creator,
+		Post:    e.Post,
+		Files:   make([]SidecarFile, 0, len(e.Files)),
+	}
+	for _, f := range e.Files {
+		local, _ := e.local(f)
+		sidecar.Files = append(sidecar.Files, SidecarFile{Name: f.Name, Remote: f.Path, Status: f.Status, Local: local})
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode sidecar error: %w", err)
+	}
+	return writeFile(filepath.Join(e.Dir, SidecarName), data)
+}
+
+// ReadSidecar read a post.json sidecar
+func ReadSidecar(path string) (Sidecar, error) {
+	var sidecar Sidecar
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sidecar, err
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return sidecar, fmt.Errorf("decode sidecar %s error: %w", path, err)
+	}
+	return sidecar, nil
+}
+
+// local return the path of the saved file relative to the post directory, with slashes
+func (e Entry) local(f kemono.FileResult) (string, bool) {
+	switch f.Status {
+	case kemono.FileDownloaded, kemono.FileExists, kemono.FileLinked:
+	default:
+		return "", false
+	}
+	rel, err := filepath.Rel(e.Dir, f.SavePath)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// localFiles the local path of every saved file, map[<remote path>]<local path>
+func (e Entry) localFiles() map[string]string {
+	files := make(map[string]string)
+	for _, f := range e.Files {
+		if local, ok := e.local(f); ok {
+			files[RemotePath(f.Path)] = local
+		}
+	}
+	return files
+}
+
+// RemotePath the path of a kemono file url without /data, e.g. /ab/cd/<hash>.png for
+// https://kemono.su/data/ab/cd/<hash>.png?f=name.png
+func RemotePath(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return strings.TrimPrefix(u.Path, "/data")
+}
+
+// rewrite return the local path of a link to a saved file, or the link unchanged
+func rewrite(files map[string]string, link string) string {
+	if local, ok := files[RemotePath(link)]; ok && link != "" {
+		return local
+	}
+	return link
+}
+
+// embedLink the url and subject of the embed of a post, if any
+func embedLink(embed kemono.Embed) (link, subject string) {
+	subject = embed.Subject
+	if subject == "" {
+		subject = embed.URL
+	}
+	return embed.URL, subject
+}
+
+func isImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".avif":
+		return true
+	}
+	return false
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
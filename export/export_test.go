@@ -0,0 +1,73 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+func TestExport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "creator", "post 1")
+	e := Entry{
+		Creator: kemono.Creator{Id: "1", Name: "creator", Service: "fanbox"},
+		Post: kemono.Post{
+			Id:        "1",
+			Service:   "fanbox",
+			User:      "1",
+			Title:     "post 1",
+			Published: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			Content: `<p>Hello <b>world</b><br><img src="https://kemono.su/data/ab/cd/hash.png"></p>` +
+				`<ul><li>one</li><li>two</li></ul><p><a href="https://example.com">site</a></p>`,
+			Attachments: []kemono.File{{Name: "1.png", Path: "/ab/cd/hash.png"}, {Name: "2.zip", Path: "/ef/gh/hash.zip"}},
+		},
+		Dir: dir,
+		Files: []kemono.FileResult{
+			{Name: "1.png", Path: "/ab/cd/hash.png", SavePath: filepath.Join(dir, "1.png"), Status: kemono.FileDownloaded},
+			{Name: "2.zip", Path: "/ef/gh/hash.zip", Status: kemono.FileFailed},
+		},
+	}
+	index := NewIndex()
+	for _, exporter := range []Exporter{JSON(), Markdown(), HTML(), index} {
+		if err := exporter.Export(e); err != nil {
+			t.Fatalf("export failed: %s", err)
+		}
+	}
+	if err := index.Close(); err != nil {
+		t.Fatalf("write index failed: %s", err)
+	}
+
+	sidecar, err := ReadSidecar(filepath.Join(dir, SidecarName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Post.Title != "post 1" || len(sidecar.Post.Attachments) != 2 || sidecar.Files[0].Local != "1.png" || sidecar.Files[1].Local != "" {
+		t.Errorf("unexpected sidecar: %+v", sidecar)
+	}
+
+	for name, want := range map[string][]string{
+		MarkdownName: {"# post 1", "Hello **world**  \n![](1.png)", "- one\n- two", "[site](https://example.com)", "- 2.zip (failed)"},
+		HTMLName:     {`<img src="1.png"/>`, `<a href="https://example.com">site</a>`, `<a href="../index.html">creator</a>`},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(data), w) {
+				t.Errorf("%s does not contain %q:\n%s", name, w, data)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(dir), IndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<a href="post%201/post.html">post 1</a>`) || !strings.Contains(string(data), `src="post%201/1.png"`) {
+		t.Errorf("unexpected index:\n%s", data)
+	}
+}
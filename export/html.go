@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var postTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Post.Title }}</title>
+<style>
+body { max-width: 960px; margin: 0 auto; padding: 1em; font-family: sans-serif; }
+img { max-width: 100%; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<p><a href="../` + IndexName + `">{{ or .Creator.Name .Creator.Id }}</a></p>
+<h1>{{ .Post.Title }}</h1>
+<p class="meta">{{ .Creator.Service }}:{{ .Post.User }} post {{ .Post.Id }}
+{{- if not .Post.Published.IsZero }}, published {{ .Post.Published.Format "2006-01-02 15:04:05" }}{{ end }}
+{{- if not .Post.Edited.IsZero }}, edited {{ .Post.Edited.Format "2006-01-02 15:04:05" }}{{ end }}</p>
+{{- if .Post.Tags }}
+<p class="meta">Tags: {{ range $i, $tag := .Post.Tags }}{{ if $i }}, {{ end }}{{ $tag }}{{ end }}</p>
+{{- end }}
+{{- if .Embed }}
+<p>Embed: <a href="{{ .Embed }}">{{ .EmbedSubject }}</a></p>
+{{- end }}
+<div class="content">
+{{ .Content }}
+</div>
+{{- if .Files }}
+<h2>Files</h2>
+{{- range .Files }}
+{{- if and .Local .Image }}
+<p><a href="{{ .Local }}"><img src="{{ .Local }}" alt="{{ .Name }}"></a></p>
+{{- else if .Local }}
+<p><a href="{{ .Local }}">{{ .Name }}</a></p>
+{{- else }}
+<p>{{ .Name }} ({{ .Status }})</p>
+{{- end }}
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+type htmlExporter struct{}
+
+// HTML write the post.html page of the post, the images and links of its content pointing to saved files are
+// rewritten to their local paths, and the files are listed below it
+func HTML() Exporter {
+	return htmlExporter{}
+}
+
+func (htmlExporter) Export(e Entry) error {
+	files := e.localFiles()
+	content, err := RewriteContent(e.Post.Content, files)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		SidecarFile
+		Image bool
+	}
+	data := struct {
+		Entry
+		Content      template.HTML
+		Embed        string
+		EmbedSubject string
+		Files        []file
+	}{Entry: e, Content: template.HTML(content)}
+	data.Embed, data.EmbedSubject = embedLink(e.Post.Embed)
+	for _, f := range e.Files {
+		local, _ := e.local(f)
+		data.Files = append(data.Files, file{
+			SidecarFile: SidecarFile{Name: f.Name, Remote: f.Path, Status: f.Status, Local: local},
+			Image:       isImage(f.Name),
+		})
+	}
+	var b bytes.Buffer
+	if err := postTemplate.Execute(&b, data); err != nil {
+		return fmt.Errorf("execute post template error: %w", err)
+	}
+	return writeFile(filepath.Join(e.Dir, HTMLName), b.Bytes())
+}
+
+// RewriteContent rewrite the src and href of the html content pointing to saved files to their local paths,
+// files is map[<remote path>]<local path>, with the remote paths of RemotePath
+func RewriteContent(content string, files map[string]string) (string, error) {
+	nodes, err := parseContent(content)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		walk(n, func(n *html.Node) {
+			if n.Type != html.ElementNode {
+				return
+			}
+			for i, a := range n.Attr {
+				if a.Key == "src" || a.Key == "href" {
+					n.Attr[i].Val = rewrite(files, a.Val)
+				}
+			}
+		})
+		if err := html.Render(&b, n); err != nil {
+			return "", fmt.Errorf("render content error: %w", err)
+		}
+	}
+	return b.String(), nil
+}
+
+// parseContent parse the html content of a post
+func parseContent(content string) ([]*html.Node, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return nil, fmt.Errorf("parse content error: %w", err)
+	}
+	return nodes, nil
+}
+
+// walk call f on n and its descendants
+func walk(n *html.Node, f func(n *html.Node)) {
+	f(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, f)
+	}
+}
+
+// attr the value of the attribute of n
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { max-width: 960px; margin: 0 auto; padding: 1em; font-family: sans-serif; }
+.post { display: flex; gap: 1em; align-items: center; border-bottom: 1px solid #ddd; padding: 0.5em 0; }
+.post img { width: 120px; height: 120px; object-fit: cover; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p class="meta">{{ len .Posts }} posts</p>
+{{- range .Posts }}
+<div class="post">
+{{- if .Thumbnail }}
+<a href="{{ .Page }}"><img src="{{ .Thumbnail }}" alt="" loading="lazy"></a>
+{{- end }}
+<div>
+<a href="{{ .Page }}">{{ .Title }}</a>
+<div class="meta">{{ if not .Published.IsZero }}{{ .Published.Format "2006-01-02" }}, {{ end }}{{ .Files }} files</div>
+</div>
+</div>
+{{- end }}
+</body>
+</html>
+`))
+
+// Index write the index.html of the creator of every entry, listing the posts of the post.json sidecars under its
+// directory, so it needs the JSON exporter. The creator directory is the parent of the post directories, like the
+// default templates make it. The indexes are written on Close, not after every post
+type Index struct {
+	lock sync.Mutex
+	// creator directories of the entries exported
+	dirs map[string]bool
+}
+
+// NewIndex create an Index exporter
+func NewIndex() *Index {
+	return &Index{dirs: make(map[string]bool)}
+}
+
+func (x *Index) Export(e Entry) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.dirs[filepath.Dir(e.Dir)] = true
+	return nil
+}
+
+// Close write the index of every creator directory exported
+func (x *Index) Close() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	dirs := make([]string, 0, len(x.dirs))
+	for dir := range x.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := WriteIndex(dir); err != nil {
+			return err
+		}
+	}
+	x.dirs = make(map[string]bool)
+	return nil
+}
+
+// indexPost a post listed in an index
+type indexPost struct {
+	Title     string
+	Published time.Time
+	Id        string
+	// Page the page of the post relative to the index: post.html, post.md or post.json, whichever exists first
+	Page string
+	// Thumbnail the first saved image of the post relative to the index
+	Thumbnail string
+	Files     int
+}
+
+// WriteIndex write dir/index.html listing the posts of the post.json sidecars under dir, newest first
+func WriteIndex(dir string) error {
+	var (
+		posts []indexPost
+		title string
+	)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != SidecarName {
+			return nil
+		}
+		sidecar, err := ReadSidecar(p)
+		if err != nil {
+			return err
+		}
+		postDir := filepath.Dir(p)
+		rel, err := filepath.Rel(dir, postDir)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		ip := indexPost{
+			Title:     sidecar.Post.Title,
+			Published: sidecar.Post.Published,
+			Id:        sidecar.Post.Id,
+			Page:      path.Join(rel, SidecarName),
+		}
+		for _, name := range []string{MarkdownName, HTMLName} {
+			if _, err := os.Stat(filepath.Join(postDir, name)); err == nil {
+				ip.Page = path.Join(rel, name)
+			}
+		}
+		for _, f := range sidecar.Files {
+			if f.Local == "" {
+				continue
+			}
+			ip.Files++
+			if ip.Thumbnail == "" && isImage(f.Name) {
+				ip.Thumbnail = path.Join(rel, f.Local)
+			}
+		}
+		if ip.Title == "" {
+			ip.Title = ip.Id
+		}
+		if title == "" {
+			title = sidecar.Creator.Name
+		}
+		posts = append(posts, ip)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read sidecars error: %w", err)
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if !posts[i].Published.Equal(posts[j].Published) {
+			return posts[i].Published.After(posts[j].Published)
+		}
+		return posts[i].Id > posts[j].Id
+	})
+	if title == "" {
+		title = filepath.Base(dir)
+	}
+
+	var b bytes.Buffer
+	err = indexTemplate.Execute(&b, struct {
+		Title string
+		Posts []indexPost
+	}{Title: title, Posts: posts})
+	if err != nil {
+		return fmt.Errorf("execute index template error: %w", err)
+	}
+	return writeFile(filepath.Join(dir, IndexName), b.Bytes())
+}
@@ -0,0 +1,44 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+const (
+	// LinksName the file name of the external links of a post
+	LinksName = "links.txt"
+	// LinksJSONName the file name of the external links of a post as json
+	LinksJSONName = "links.json"
+)
+
+type linksExporter struct{}
+
+// Links write the external links of the post, see kemono.ExternalLinks, to links.txt, one <host> <url> a line,
+// and to links.json. Nothing is written for a post without external links
+func Links() Exporter {
+	return linksExporter{}
+}
+
+func (linksExporter) Export(e Entry) error {
+	links := kemono.ExternalLinks(e.Post)
+	if len(links) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	for _, link := range links {
+		fmt.Fprintf(&b, "%s %s\n", link.Host, link.URL)
+	}
+	if err := writeFile(filepath.Join(e.Dir, LinksName), []byte(b.String())); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode links error: %w", err)
+	}
+	return writeFile(filepath.Join(e.Dir, LinksJSONName), data)
+}
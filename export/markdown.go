@@ -0,0 +1,216 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	spaces     = regexp.MustCompile(`\s+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	// markdownEscaper escape the characters with a meaning in Markdown text
+	markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`)
+)
+
+type markdownExporter struct{}
+
+// Markdown write the post.md of the post, its content converted to Markdown with the links to saved files
+// rewritten to their local paths, and the files listed below it
+func Markdown() Exporter {
+	return markdownExporter{}
+}
+
+func (markdownExporter) Export(e Entry) error {
+	files := e.localFiles()
+	content, err := ToMarkdown(e.Post.Content, func(link string) string {
+		return rewrite(files, link)
+	})
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", markdownEscaper.Replace(e.Post.Title))
+	fmt.Fprintf(&b, "- Creator: %s (%s:%s)\n", markdownEscaper.Replace(e.Creator.Name), e.Post.Service, e.Post.User)
+	fmt.Fprintf(&b, "- Post: %s\n", e.Post.Id)
+	if !e.Post.Published.IsZero() {
+		fmt.Fprintf(&b, "- Published: %s\n", e.Post.Published.Format("2006-01-02 15:04:05"))
+	}
+	if !e.Post.Edited.IsZero() {
+		fmt.Fprintf(&b, "- Edited: %s\n", e.Post.Edited.Format("2006-01-02 15:04:05"))
+	}
+	if len(e.Post.Tags) > 0 {
+		fmt.Fprintf(&b, "- Tags: %s\n", markdownEscaper.Replace(strings.Join(e.Post.Tags, ", ")))
+	}
+	if link, subject := embedLink(e.Post.Embed); link != "" {
+		fmt.Fprintf(&b, "- Embed: [%s](%s)\n", markdownEscaper.Replace(subject), link)
+	}
+	if content != "" {
+		b.WriteString("\n" + content + "\n")
+	}
+	if len(e.Files) > 0 {
+		b.WriteString("\n## Files\n\n")
+		for _, f := range e.Files {
+			local, ok := e.local(f)
+			switch {
+			case ok && isImage(f.Name):
+				fmt.Fprintf(&b, "![%s](%s)\n\n", markdownEscaper.Replace(f.Name), markdownLink(local))
+			case ok:
+				fmt.Fprintf(&b, "- [%s](%s)\n\n", markdownEscaper.Replace(f.Name), markdownLink(local))
+			default:
+				fmt.Fprintf(&b, "- %s (%s)\n\n", markdownEscaper.Replace(f.Name), f.Status)
+			}
+		}
+	}
+	return writeFile(filepath.Join(e.Dir, MarkdownName), []byte(strings.TrimSpace(b.String())+"\n"))
+}
+
+// ToMarkdown convert the html content of a post to Markdown, link is applied to the href of links and the src of
+// images, e.g. to point them to local files
+func ToMarkdown(content string, link func(string) string) (string, error) {
+	nodes, err := parseContent(content)
+	if err != nil {
+		return "", err
+	}
+	c := converter{link: link}
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(c.convert(n))
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(b.String(), "\n\n")), nil
+}
+
+// converter convert html nodes to Markdown
+type converter struct {
+	link func(string) string
+}
+
+func (c converter) convert(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(spaces.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return c.children(n)
+	}
+	switch n.DataAtom {
+	case atom.Script, atom.Style:
+		return ""
+	case atom.Br:
+		return "  \n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.P, atom.Div:
+		return block(strings.TrimSpace(c.children(n)))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		return block(strings.Repeat("#", level) + " " + strings.TrimSpace(c.children(n)))
+	case atom.Strong, atom.B:
+		return wrap(c.children(n), "**")
+	case atom.Em, atom.I:
+		return wrap(c.children(n), "_")
+	case atom.Code:
+		return "`" + text(n) + "`"
+	case atom.Pre:
+		return block("```\n" + strings.Trim(text(n), "\n") + "\n```")
+	case atom.A:
+		content := strings.TrimSpace(c.children(n))
+		href := attr(n, "href")
+		if href == "" {
+			return content
+		}
+		if content == "" {
+			content = markdownEscaper.Replace(href)
+		}
+		return "[" + content + "](" + markdownLink(c.apply(href)) + ")"
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + markdownEscaper.Replace(attr(n, "alt")) + "](" + markdownLink(c.apply(src)) + ")"
+	case atom.Ul, atom.Ol:
+		return block(c.list(n))
+	case atom.Blockquote:
+		lines := strings.Split(strings.TrimSpace(c.children(n)), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return block(strings.Join(lines, "\n"))
+	}
+	return c.children(n)
+}
+
+func (c converter) children(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(c.convert(child))
+	}
+	return b.String()
+}
+
+// list convert the items of a ul or ol, the lines of an item are indented under its marker
+func (c converter) list(n *html.Node) string {
+	var items []string
+	i := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+			continue
+		}
+		i++
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		content := strings.TrimSpace(blankLines.ReplaceAllString(c.children(child), "\n\n"))
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.ReplaceAll(content, "\n", "\n"+indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c converter) apply(link string) string {
+	if c.link == nil {
+		return link
+	}
+	return c.link(link)
+}
+
+// block separate s from the text around it by a blank line
+func block(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "\n\n" + s + "\n\n"
+}
+
+// wrap put the marker around s, keeping its surrounding spaces outside
+func wrap(s, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	start := strings.Index(s, trimmed)
+	return s[:start] + marker + trimmed + marker + s[start+len(trimmed):]
+}
+
+// text the text of n and its descendants, not escaped
+func text(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+	})
+	return b.String()
+}
+
+// markdownLink escape the characters of a link ending it in Markdown
+func markdownLink(link string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(link)
+}
@@ -0,0 +1,169 @@
+// Package external downloads the files behind the external links of posts, for the file hosts with a direct
+// download url: pixeldrain and dropbox. Mega and Google Drive links are only listed, see kemono.ExternalLinks.
+package external
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/utils"
+)
+
+// New return the fetcher of a host: pixeldrain or dropbox, it downloads with client
+func New(host string, client *http.Client) (kemono.ExternalFetcher, error) {
+	switch host {
+	case kemono.HostPixeldrain:
+		return Pixeldrain(client), nil
+	case kemono.HostDropbox:
+		return Dropbox(client), nil
+	default:
+		return nil, fmt.Errorf("unknown external host %s, must be pixeldrain or dropbox", host)
+	}
+}
+
+// directFetcher download the file of a link from its direct download url
+type directFetcher struct {
+	host   string
+	client *http.Client
+	// download return the direct download url of the link, ok is false if there is none
+	download func(u *url.URL) (string, bool)
+}
+
+// Pixeldrain download the files of pixeldrain.com/u/<id> links
+func Pixeldrain(client *http.Client) kemono.ExternalFetcher {
+	return &directFetcher{
+		host:   kemono.HostPixeldrain,
+		client: client,
+		download: func(u *url.URL) (string, bool) {
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if len(parts) != 2 || parts[0] != "u" || parts[1] == "" {
+				return "", false
+			}
+			return fmt.Sprintf("https://pixeldrain.com/api/file/%s?download", url.PathEscape(parts[1])), true
+		},
+	}
+}
+
+// Dropbox download the files of dropbox shared links, a shared folder is downloaded as a zip
+func Dropbox(client *http.Client) kemono.ExternalFetcher {
+	return &directFetcher{
+		host:   kemono.HostDropbox,
+		client: client,
+		download: func(u *url.URL) (string, bool) {
+			if !strings.HasPrefix(u.Path, "/s/") && !strings.HasPrefix(u.Path, "/sh/") && !strings.HasPrefix(u.Path, "/scl/") {
+				return "", false
+			}
+			d := *u
+			q := d.Query()
+			q.Set("dl", "1")
+			d.RawQuery = q.Encode()
+			return d.String(), true
+		},
+	}
+}
+
+func (f *directFetcher) Match(link kemono.ExternalLink) bool {
+	if link.Host != f.host {
+		return false
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return false
+	}
+	_, ok := f.download(u)
+	return ok
+}
+
+// Fetch download the file of the link into dir, named after its Content-Disposition, or the base of the url.
+// The name is asked with a HEAD request first, so a file already in dir is not downloaded again
+func (f *directFetcher) Fetch(ctx context.Context, link kemono.ExternalLink, dir string) error {
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return err
+	}
+	download, ok := f.download(u)
+	if !ok {
+		return fmt.Errorf("no download url for %s", link.URL)
+	}
+	// a host refusing HEAD is asked with the GET below
+	if resp, err := f.do(ctx, http.MethodHead, download); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if _, err := os.Stat(filepath.Join(dir, fileName(resp, u))); err == nil {
+				return nil
+			}
+		}
+	} else if ctx.Err() != nil {
+		return fmt.Errorf("fetch %s error: %w", link.URL, err)
+	}
+
+	resp, err := f.do(ctx, http.MethodGet, download)
+	if err != nil {
+		return fmt.Errorf("fetch %s error: %w", link.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch %s error: http status %s", link.URL, resp.Status)
+	}
+
+	target := filepath.Join(dir, fileName(resp, u))
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("fetch %s error: %w", link.URL, err)
+	}
+	return os.Rename(tmp, target)
+}
+
+// do send a request to the download url
+func (f *directFetcher) do(ctx context.Context, method, download string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, download, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// fileName the name of the downloaded file, from the Content-Disposition of the response or the link
+func fileName(resp *http.Response, link *url.URL) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(link.Path)
+	}
+	name = utils.ValidDirectoryName(filepath.Base(name))
+	if name == "" {
+		name = "external"
+	}
+	return name
+}
@@ -0,0 +1,102 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+// rewriteTransport send every request to the test server
+type rewriteTransport struct {
+	target *url.URL
+	// requests the methods and urls requested before the rewrite
+	requests []string
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req.Method+" "+req.URL.String())
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestExternalLinks(t *testing.T) {
+	post := kemono.Post{
+		Content: `<p><a href="https://mega.nz/file/abc#key">mega</a> <a href="https://kemono.su/fanbox/user/1">kemono</a>` +
+			`<img src="/data/ab/cd/hash.png"> see https://pixeldrain.com/u/xyz.</p>` +
+			`<a href="https://www.dropbox.com/s/abc/file.zip?dl=0">dropbox</a><a href="https://mega.nz/file/abc#key">again</a>`,
+		Embed: kemono.Embed{URL: "https://drive.google.com/file/d/1/view", Subject: "drive"},
+	}
+	want := []kemono.ExternalLink{
+		{URL: "https://mega.nz/file/abc#key", Host: kemono.HostMega, Source: kemono.LinkContent},
+		{URL: "https://pixeldrain.com/u/xyz", Host: kemono.HostPixeldrain, Source: kemono.LinkContent},
+		{URL: "https://www.dropbox.com/s/abc/file.zip?dl=0", Host: kemono.HostDropbox, Source: kemono.LinkContent},
+		{URL: "https://drive.google.com/file/d/1/view", Host: kemono.HostGoogleDrive, Source: kemono.LinkEmbed},
+	}
+	got := kemono.ExternalLinks(post)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d links, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("link %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/file/xyz" {
+			w.Header().Set("Content-Disposition", `attachment; filename="pack.zip"`)
+		}
+		_, _ = w.Write([]byte("data " + r.URL.Path))
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	transport := &rewriteTransport{target: target}
+	client := &http.Client{Transport: transport}
+
+	dir := t.TempDir()
+	for _, c := range []struct {
+		fetcher kemono.ExternalFetcher
+		link    kemono.ExternalLink
+		file    string
+		request string
+	}{
+		{Pixeldrain(client), kemono.ExternalLink{URL: "https://pixeldrain.com/u/xyz", Host: kemono.HostPixeldrain}, "pack.zip", "GET https://pixeldrain.com/api/file/xyz?download"},
+		{Dropbox(client), kemono.ExternalLink{URL: "https://www.dropbox.com/s/abc/file.zip?dl=0", Host: kemono.HostDropbox}, "file.zip", "GET https://www.dropbox.com/s/abc/file.zip?dl=1"},
+	} {
+		if !c.fetcher.Match(c.link) {
+			t.Fatalf("%s should match", c.link.URL)
+		}
+		if err := c.fetcher.Fetch(context.Background(), c.link, dir); err != nil {
+			t.Fatalf("fetch %s failed: %s", c.link.URL, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, c.file)); err != nil {
+			t.Errorf("%s not saved: %s", c.file, err)
+		}
+		if last := transport.requests[len(transport.requests)-1]; last != c.request {
+			t.Errorf("expected request %s, got %s", c.request, last)
+		}
+
+		// the file is there, only its name is asked
+		n := len(transport.requests)
+		if err := c.fetcher.Fetch(context.Background(), c.link, dir); err != nil {
+			t.Fatalf("fetch %s again failed: %s", c.link.URL, err)
+		}
+		if got := transport.requests[n:]; len(got) != 1 || !strings.HasPrefix(got[0], "HEAD ") {
+			t.Errorf("expected a HEAD request only, got %v", got)
+		}
+	}
+
+	if Pixeldrain(client).Match(kemono.ExternalLink{URL: "https://pixeldrain.com/l/list", Host: kemono.HostPixeldrain}) {
+		t.Errorf("a pixeldrain list should not match")
+	}
+}
@@ -0,0 +1,95 @@
+package kemono
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ContentFiles return the files of the site referenced in the html content of the post, by the src of its images,
+// videos and audios and the href of its links, in the order they appear and without duplicates. Only the urls under
+// /data/ of the site, a kemono or coomer host, or no host are files. Their path is like the one of an attachment,
+// e.g. /ab/cd/<hash>.png, and their name is the f query parameter, or the base of the path
+func (k *Kemono) ContentFiles(post Post) []File {
+	if post.Content == "" {
+		return nil
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(post.Content), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		k.log.Printf("parse content of post %s error: %s", post.Id, err)
+		return nil
+	}
+	base, _ := url.Parse(k.BaseURL())
+
+	var files []File
+	seen := make(map[string]bool)
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, a := range n.Attr {
+				if a.Key != "src" && a.Key != "href" {
+					continue
+				}
+				f, ok := contentFile(a.Val, base)
+				if ok && !seen[f.Path] {
+					seen[f.Path] = true
+					files = append(files, f)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	for _, n := range nodes {
+		visit(n)
+	}
+	return files
+}
+
+// contentFile the file of a url of the content, ok is false if it is not a file of the site
+func contentFile(link string, base *url.URL) (File, bool) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !strings.HasPrefix(u.Path, "/data/") {
+		return File{}, false
+	}
+	if u.Host != "" && !(base != nil && u.Host == base.Host) && !siteHost(u.Hostname()) {
+		return File{}, false
+	}
+	p := strings.TrimPrefix(u.Path, "/data")
+	name := u.Query().Get("f")
+	if name == "" {
+		name = path.Base(p)
+	} else if path.Ext(name) == "" {
+		name += path.Ext(p)
+	}
+	return File{Name: name, Path: p}, true
+}
+
+// siteHost report whether the host is kemono or coomer, or a subdomain of them, e.g. n1.kemono.su
+func siteHost(hostname string) bool {
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "kemono" || label == "coomer" {
+			return true
+		}
+	}
+	return false
+}
+
+// addContentFiles append the content files not among the attachments to them
+func addContentFiles(attachments []File, files []File) []File {
+	seen := make(map[string]bool)
+	for _, a := range attachments {
+		seen[strings.TrimPrefix(a.Path, "/data")] = true
+	}
+	for _, f := range files {
+		if !seen[f.Path] {
+			seen[f.Path] = true
+			attachments = append(attachments, f)
+		}
+	}
+	return attachments
+}
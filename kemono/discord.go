@@ -0,0 +1,160 @@
+package kemono
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// discordPageSize the number of messages of a discord channel page
+const discordPageSize = 150
+
+// DiscordChannel a channel of an archived discord server
+type DiscordChannel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// DiscordAuthor the author of a discord message
+type DiscordAuthor struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// DiscordEmbed a link embedded in a discord message
+type DiscordEmbed struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// DiscordMessage a message of a discord channel
+type DiscordMessage struct {
+	Id          string         `json:"id"`
+	Author      DiscordAuthor  `json:"author"`
+	Server      string         `json:"server"`
+	Channel     string         `json:"channel"`
+	Content     string         `json:"content"`
+	Added       string         `json:"added"`
+	Published   string         `json:"published"`
+	Edited      string         `json:"edited"`
+	Embeds      []DiscordEmbed `json:"embeds"`
+	Attachments []File         `json:"attachments"`
+}
+
+// Post map the message onto a post of the discord server: the user is the server, the title the author and the
+// first line of the message, and the attachments its files
+func (m DiscordMessage) Post() (Post, error) {
+	raw := PostRaw{
+		Id:          m.Id,
+		Service:     "discord",
+		User:        m.Server,
+		Title:       m.title(),
+		Content:     m.Content,
+		Added:       m.Added,
+		Published:   m.Published,
+		Edited:      m.Edited,
+		Attachments: m.Attachments,
+	}
+	if len(m.Embeds) > 0 {
+		raw.Embed = Embed{URL: m.Embeds[0].URL, Subject: m.Embeds[0].Title, Description: m.Embeds[0].Description}
+	}
+	return raw.Parse()
+}
+
+// title the author and the first line of the message, at most 50 characters of it
+func (m DiscordMessage) title() string {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(m.Content), "\n", 2)[0])
+	if utf8.RuneCountInString(line) > 50 {
+		line = string([]rune(line)[:50])
+	}
+	switch {
+	case m.Author.Username == "":
+		return line
+	case line == "":
+		return m.Author.Username
+	default:
+		return m.Author.Username + " " + line
+	}
+}
+
+// FetchDiscordChannels fetch the channels of a discord server, see FetchDiscordChannelsContext
+func (k *Kemono) FetchDiscordChannels(server string) ([]DiscordChannel, error) {
+	return k.FetchDiscordChannelsContext(context.Background(), server)
+}
+
+// FetchDiscordChannelsContext fetch the channels of a discord server, the request is bound to ctx
+func (k *Kemono) FetchDiscordChannelsContext(ctx context.Context, server string) (channels []DiscordChannel, err error) {
+	k.log.Printf("fetching channels of discord server %s...", server)
+	url := fmt.Sprintf("%s/api/v1/discord/channel/lookup/%s", k.BaseURL(), server)
+	if err := k.getJSON(ctx, url, "discord channel list", &channels); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// FetchDiscordMessagePages fetch the messages of a channel page by page, see FetchDiscordMessagePagesContext
+func (k *Kemono) FetchDiscordMessagePages(channel string, yield func(page []Post) bool) error {
+	return k.FetchDiscordMessagePagesContext(context.Background(), channel, yield)
+}
+
+// FetchDiscordMessagePagesContext fetch the messages of a discord channel page by page, newest first, mapped onto
+// posts, and pass every page to yield as it arrives. Paging stops after the last page, when yield returns false,
+// or when ctx is done
+func (k *Kemono) FetchDiscordMessagePagesContext(ctx context.Context, channel string, yield func(page []Post) bool) error {
+	url := fmt.Sprintf("%s/api/v1/discord/channel/%s", k.BaseURL(), channel)
+	for page := 0; ; page++ {
+		k.log.Printf("fetching channel %s page %d...", channel, page)
+		var messages []DiscordMessage
+		if err := k.getJSON(ctx, fmt.Sprintf("%s?o=%d", url, page*discordPageSize), "discord channel", &messages); err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		posts := make([]Post, 0, len(messages))
+		for _, m := range messages {
+			post, err := m.Post()
+			if err != nil {
+				k.reportError(err)
+			}
+			posts = append(posts, post)
+		}
+		if !yield(posts) || len(messages) < discordPageSize {
+			// a short page is the last one
+			return nil
+		}
+	}
+}
+
+// fetchDiscord fetch the messages of the channels of a discord server, the channels given by WithDiscordChannels or
+// every channel of the server, until the first message past the cutoffs of the server
+func (k *Kemono) fetchDiscord(ctx context.Context, server Creator) ([]Post, error) {
+	channels := k.discordChannels[server.Id]
+	if len(channels) == 0 {
+		cs, err := k.FetchDiscordChannelsContext(ctx, server.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cs {
+			channels = append(channels, c.Id)
+		}
+	}
+	var posts []Post
+	for _, channel := range channels {
+		err := k.FetchDiscordMessagePagesContext(ctx, channel, func(page []Post) bool {
+			for _, post := range page {
+				if k.pastCutoff(post) {
+					return false
+				}
+				posts = append(posts, post)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return posts, nil
+}
@@ -0,0 +1,120 @@
+package kemono
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Failure a file that failed to download in a previous run
+type Failure struct {
+	Site    string `json:"site"`
+	Service string `json:"service"`
+	User    string `json:"user"`
+	Post    string `json:"post"`
+	Title   string `json:"title"`
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	// Path the kemono path of the file
+	Path string `json:"path"`
+	// SavePath where the file should be saved
+	SavePath string `json:"save_path"`
+}
+
+// Failures return the failed files of the report
+func (r *Report) Failures() []Failure {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var failures []Failure
+	for _, p := range r.Posts {
+		for _, f := range p.Failed() {
+			failures = append(failures, Failure{
+				Site:     r.Site,
+				Service:  p.Service,
+				User:     p.User,
+				Post:     p.Id,
+				Title:    p.Title,
+				Index:    f.Index,
+				Name:     f.Name,
+				Path:     f.Path,
+				SavePath: f.SavePath,
+			})
+		}
+	}
+	return failures
+}
+
+// ReadFailures read the failed files from a report written by a previous run,
+// the file may hold a single report or an array of reports
+func ReadFailures(path string) ([]Failure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read report error: %w", err)
+	}
+	var reports []*Report
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var r Report
+		err = json.Unmarshal(data, &r)
+		reports = append(reports, &r)
+	} else {
+		err = json.Unmarshal(data, &reports)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal report error: %w", err)
+	}
+	var failures []Failure
+	for _, r := range reports {
+		failures = append(failures, r.Failures()...)
+	}
+	return failures, nil
+}
+
+// RetryFailed re-download the failed files, without fetching the creator or post list
+func (k *Kemono) RetryFailed(failures []Failure) error {
+	return k.RetryFailedContext(context.Background(), failures)
+}
+
+// RetryFailedContext re-download the failed files, stops when ctx is done and returns a *CancelledError.
+// The files are saved to the save path recorded in the failure
+func (k *Kemono) RetryFailedContext(ctx context.Context, failures []Failure) error {
+	k.report = newReport(k.Site)
+	defer k.report.finish()
+
+	type postKey struct {
+		service, user, post string
+	}
+	var (
+		order []postKey
+		files = make(map[postKey][]FileWithIndex)
+		posts = make(map[postKey]Post)
+	)
+	for _, f := range failures {
+		key := postKey{f.Service, f.User, f.Post}
+		if _, ok := posts[key]; !ok {
+			order = append(order, key)
+			posts[key] = Post{Id: f.Post, Service: f.Service, User: f.User, Title: f.Title}
+		}
+		file := File{Name: f.Name, Path: f.Path}.Index(f.Index)
+		file.Target = f.SavePath
+		files[key] = append(files[key], file)
+	}
+
+	k.log.Printf("Retry %d failed files of %d posts", len(failures), len(order))
+	for _, key := range order {
+		if err := Cancelled(ctx); err != nil {
+			return err
+		}
+		post := posts[key]
+		pr := PostReport{
+			Service: post.Service,
+			User:    post.User,
+			Id:      post.Id,
+			Title:   post.Title,
+		}
+		k.downloadFiles(ctx, NewCreator(key.service, key.user), post, files[key], &pr)
+		k.report.addPost(pr)
+	}
+	return Cancelled(ctx)
+}
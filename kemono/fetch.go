@@ -2,6 +2,7 @@ package kemono
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,14 +14,26 @@ import (
 	"github.com/elvis972602/kemono-scraper/utils"
 )
 
+// maxRetryInterval the longest wait between two attempts of the default retry policy
+const maxRetryInterval = 2 * time.Minute
+
 // FetchCreators fetch Creator list
 func (k *Kemono) FetchCreators() (creators []Creator, err error) {
+	return k.FetchCreatorsContext(context.Background())
+}
+
+// FetchCreatorsContext fetch Creator list, the request is bound to ctx
+func (k *Kemono) FetchCreatorsContext(ctx context.Context) (creators []Creator, err error) {
 	k.log.Print("fetching creator list...")
-	url := fmt.Sprintf("https://%s.su/api/v1/creators", k.Site)
-	resp, err := k.Downloader.Get(url)
+	url := fmt.Sprintf("%s/api/v1/creators", k.BaseURL())
+	resp, err := k.Downloader.GetContext(ctx, url)
 	if err != nil {
+		if cerr := Cancelled(ctx); cerr != nil {
+			return nil, cerr
+		}
 		return nil, fmt.Errorf("fetch creator list error: %s", err)
 	}
+	defer resp.Body.Close()
 
 	reader, err := handleCompressedHTTPResponse(resp)
 	if err != nil {
@@ -29,6 +42,9 @@ func (k *Kemono) FetchCreators() (creators []Creator, err error) {
 
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
+		if cerr := Cancelled(ctx); cerr != nil {
+			return nil, cerr
+		}
 		return nil, fmt.Errorf("fetch creator list error: %s", err)
 	}
 	err = json.Unmarshal(data, &creators)
@@ -40,102 +56,241 @@ func (k *Kemono) FetchCreators() (creators []Creator, err error) {
 
 // FetchPosts fetch post list
 func (k *Kemono) FetchPosts(service, id string) (posts []Post, err error) {
-	url := fmt.Sprintf("https://%s.su/api/v1/%s/user/%s", k.Site, service, id)
+	return k.FetchPostsContext(context.Background(), service, id)
+}
+
+// FetchPostsContext fetch post list, stops paging when ctx is done
+func (k *Kemono) FetchPostsContext(ctx context.Context, service, id string) (posts []Post, err error) {
+	err = k.FetchPostPagesContext(ctx, service, id, func(page []Post) bool {
+		posts = append(posts, page...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// FetchPostPages fetch the post list page by page, see FetchPostPagesContext
+func (k *Kemono) FetchPostPages(service, id string, yield func(page []Post) bool) error {
+	return k.FetchPostPagesContext(context.Background(), service, id, yield)
+}
+
+// FetchPostPagesContext fetch the post list page by page, newest first, and pass every page to yield as it arrives.
+// Paging stops after the last page, when yield returns false, or when ctx is done
+func (k *Kemono) FetchPostPagesContext(ctx context.Context, service, id string, yield func(page []Post) bool) error {
+	url := fmt.Sprintf("%s/api/v1/%s/user/%s", k.BaseURL(), service, id)
 	perUnit := 50
-	fetch := func(page int) (err error, finish bool) {
+	for page := 0; ; page++ {
 		k.log.Printf("fetching post list page %d...", page)
-		purl := fmt.Sprintf("%s?o=%d", url, page*perUnit)
-
-		retryCount := 0
-		for retryCount < k.retry {
-			resp, err := k.Downloader.Get(purl)
-			if err != nil {
-				k.log.Printf("fetch post list error: %v", err)
-				time.Sleep(k.retryInterval)
-				retryCount++
-				continue
-			}
+		var pr []PostRaw
+		if err := k.getJSON(ctx, fmt.Sprintf("%s?o=%d", url, page*perUnit), "post list", &pr); err != nil {
+			return err
+		}
+		if len(pr) == 0 {
+			// final page
+			return nil
+		}
+		if !yield(k.parsePosts(pr)) {
+			return nil
+		}
+	}
+}
 
-			if resp.StatusCode != http.StatusOK {
-				k.log.Printf("fetch post list error: %s", resp.Status)
-				time.Sleep(k.retryInterval)
-				retryCount++
-				continue
-			}
+// FetchPost fetch a single post, see FetchPostContext
+func (k *Kemono) FetchPost(service, user, id string) (Post, error) {
+	return k.FetchPostContext(context.Background(), service, user, id)
+}
 
-			reader, err := handleCompressedHTTPResponse(resp)
-			if err != nil {
-				return err, false
-			}
+// FetchPostContext fetch a single post of a creator with one request, instead of paging through its post list
+func (k *Kemono) FetchPostContext(ctx context.Context, service, user, id string) (Post, error) {
+	k.log.Printf("fetching post %s...", id)
+	url := fmt.Sprintf("%s/api/v1/%s/user/%s/post/%s", k.BaseURL(), service, user, id)
+	var data json.RawMessage
+	if err := k.getJSON(ctx, url, "post "+id, &data); err != nil {
+		return Post{}, err
+	}
 
-			data, err := ioutil.ReadAll(reader)
-			if err != nil {
-				return fmt.Errorf("fetch post list error: %s", err), false
+	// the post is either the whole body or wrapped with the servers of its files
+	var wrapped struct {
+		Post        *PostRaw `json:"post"`
+		Attachments []File   `json:"attachments"`
+	}
+	var pr PostRaw
+	if err := json.Unmarshal(data, &wrapped); err == nil && wrapped.Post != nil {
+		pr = *wrapped.Post
+		for i, a := range pr.Attachments {
+			for _, hint := range wrapped.Attachments {
+				if hint.Path == a.Path && a.Server == "" {
+					pr.Attachments[i].Server = hint.Server
+				}
 			}
-			reader.Close()
+		}
+	} else if err := json.Unmarshal(data, &pr); err != nil {
+		return Post{}, fmt.Errorf("unmarshal post %s error: %s", id, err)
+	}
+	if pr.Id == "" {
+		return Post{}, fmt.Errorf("post %s not found", id)
+	}
+	post, err := pr.Parse()
+	if err != nil {
+		k.reportError(err)
+	}
+	return post, nil
+}
+
+// parsePosts parse the raw posts of a page, a post with an invalid date is kept and the error reported
+func (k *Kemono) parsePosts(pr []PostRaw) []Post {
+	posts := make([]Post, 0, len(pr))
+	for _, p := range pr {
+		post, err := p.Parse()
+		if err != nil {
+			k.reportError(err)
+		}
+		posts = append(posts, post)
+	}
+	return posts
+}
 
-			var pr []PostRaw
-			err = json.Unmarshal(data, &pr)
-			if err != nil {
-				return fmt.Errorf("unmarshal post list error: %s", err), false
+// getJSON get url with the retry policy and decode its json body into v, what names the request in errors
+func (k *Kemono) getJSON(ctx context.Context, url, what string, v interface{}) error {
+	var attempt utils.Attempt
+	for {
+		if err := Cancelled(ctx); err != nil {
+			return err
+		}
+		resp, err := k.Downloader.GetContext(ctx, url)
+		if err != nil {
+			if cerr := Cancelled(ctx); cerr != nil {
+				return cerr
 			}
-			if len(pr) == 0 {
-				// final page
-				return nil, true
+			attempt.StatusCode, attempt.Header, attempt.Err = 0, nil, err
+			attempt.ConnFailures++
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("http status %s", resp.Status)
+			attempt.StatusCode, attempt.Header, attempt.Err = resp.StatusCode, resp.Header, err
+			attempt.StatusFailures++
+		}
+		if err != nil {
+			delay, ok := k.retryPolicy.Retry(attempt)
+			if !ok {
+				return fmt.Errorf("fetch %s error: %w", what, err)
 			}
-			for _, p := range pr {
-				posts = append(posts, p.ParasTime())
+			k.log.Printf("fetch %s error: %v, retry after %.1f seconds...", what, err, delay.Seconds())
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
 			}
-			return nil, false
+			continue
 		}
 
-		return fmt.Errorf("fetch post list error: maximum retry count exceeded"), false
-	}
+		reader, err := handleCompressedHTTPResponse(resp)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
 
-	for i := 0; ; i++ {
-		err, finish := fetch(i)
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		resp.Body.Close()
 		if err != nil {
-			return nil, err
+			if cerr := Cancelled(ctx); cerr != nil {
+				return cerr
+			}
+			return fmt.Errorf("fetch %s error: %s", what, err)
 		}
-		if finish {
-			break
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("unmarshal %s error: %s", what, err)
 		}
+		return nil
+	}
+}
+
+// FetchPostsUntil fetch the post list, newest first, until the first post past a cutoff, see FetchPostsUntilContext
+func (k *Kemono) FetchPostsUntil(service, id string, cutoff ...PostCutoff) (posts []Post, err error) {
+	return k.FetchPostsUntilContext(context.Background(), service, id, cutoff...)
+}
+
+// FetchPostsUntilContext fetch the post list, newest first, until the first post past a cutoff, e.g.
+// SincePostCutoff(id) for the posts newer than the last one synced. The post and the older ones are left out
+func (k *Kemono) FetchPostsUntilContext(ctx context.Context, service, id string, cutoff ...PostCutoff) (posts []Post, err error) {
+	err = k.FetchPostPagesContext(ctx, service, id, func(page []Post) bool {
+		for _, post := range page {
+			for _, c := range cutoff {
+				if c(post) {
+					return false
+				}
+			}
+			posts = append(posts, post)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 	return
 }
 
 // DownloadPosts download posts
 func (k *Kemono) DownloadPosts(creator Creator, posts []Post) (err error) {
+	return k.DownloadPostsContext(context.Background(), creator, posts)
+}
+
+// DownloadPostsContext download posts, stops when ctx is done and returns a *CancelledError.
+// If files failed or a content could not be written, it returns a *DownloadError with the reports of these posts
+func (k *Kemono) DownloadPostsContext(ctx context.Context, creator Creator, posts []Post) (err error) {
+	if k.report == nil {
+		k.report = newReport(k.Site)
+	}
+	start := k.report.postCount()
+	if k.dryRun && k.plan == nil {
+		k.plan = &Plan{Site: k.Site}
+	}
+	jobs := make(chan postJob, len(posts))
 	for _, post := range posts {
-		k.log.Printf("download post: %s", utils.ValidDirectoryName(post.Title))
-		if post.Content != "" {
-			err = k.Downloader.WriteContent(creator, post, post.Content)
-			if err != nil {
-				k.log.Printf("write content error: %s", err)
-			}
-		}
-		if len(post.Attachments) == 0 {
-			// no attachment
-			continue
-		}
-		attachmentsChan := make(chan FileWithIndex, len(post.Attachments))
-		for _, a := range AddIndexToAttachments(post.Attachments) {
-			attachmentsChan <- a
-		}
-		errChan := k.Downloader.Download(attachmentsChan, creator, post)
-		for i := 0; i < len(errChan); i++ {
-			err, ok := <-errChan
-			if ok {
-				k.log.Printf("download post error: %s", err)
-				// TODO: record error...
-			} else {
-				break
-			}
+		jobs <- postJob{creator: creator, post: post}
+	}
+	close(jobs)
+	k.downloadStage(ctx, jobs)
+	if err := Cancelled(ctx); err != nil {
+		return err
+	}
+	if failed := k.report.failedSince(start); len(failed) > 0 {
+		return &DownloadError{Posts: failed}
+	}
+	return nil
+}
+
+// downloadFiles download files of the post, add the outcomes to pr and report if any file failed
+func (k *Kemono) downloadFiles(ctx context.Context, creator Creator, post Post, files []FileWithIndex, pr *PostReport) (failed bool) {
+	filesChan := make(chan FileWithIndex, len(files))
+	for _, f := range files {
+		filesChan <- f
+	}
+	close(filesChan)
+	results := k.Downloader.DownloadContext(ctx, filesChan, creator, post)
+	for r := range results {
+		if r.Status == FileFailed {
+			failed = true
+			k.log.Printf("download post error: %s", r.Err)
 		}
+		pr.Files = append(pr.Files, r)
 	}
 	return
 }
 
+// sleepContext sleep for d, return a *CancelledError if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return Cancelled(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
 func handleCompressedHTTPResponse(resp *http.Response) (io.ReadCloser, error) {
 	switch resp.Header.Get("Content-Encoding") {
 	case "gzip":
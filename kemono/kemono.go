@@ -1,20 +1,113 @@
 package kemono
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/elvis972602/kemono-scraper/utils"
 )
 
 type Downloader interface {
+	// Download download files until the caller closes the channel, the returned channel
+	// yields the errors of failed files and is closed when all files are done
+	Download(<-chan FileWithIndex, Creator, Post) <-chan error
+	// DownloadContext same as Download, but stops when ctx is done,
+	// and yields the outcome of every file
+	DownloadContext(context.Context, <-chan FileWithIndex, Creator, Post) <-chan FileResult
+	Get(url string) (resp *http.Response, err error)
+	// GetContext same as Get, but the request is bound to ctx
+	GetContext(ctx context.Context, url string) (resp *http.Response, err error)
+	WriteContent(Creator, Post, string) error
+}
+
+// BasicDownloader the Downloader interface before DownloadContext and GetContext were added, see ContextDownloader
+type BasicDownloader interface {
 	Download(<-chan FileWithIndex, Creator, Post) <-chan error
 	Get(url string) (resp *http.Response, err error)
 	WriteContent(Creator, Post, string) error
 }
 
+// ContextDownloader adapt a BasicDownloader to Downloader. ctx is checked before every file and request, but does
+// not stop them, and the outcome of a file is only downloaded or failed
+func ContextDownloader(d BasicDownloader) Downloader {
+	return &contextDownloader{BasicDownloader: d}
+}
+
+type contextDownloader struct {
+	BasicDownloader
+}
+
+func (c *contextDownloader) DownloadContext(ctx context.Context, files <-chan FileWithIndex, creator Creator, post Post) <-chan FileResult {
+	results := make(chan FileResult)
+	go func() {
+		defer close(results)
+		for file := range files {
+			result := NewFileResult(file, "", FileDownloaded)
+			if err := Cancelled(ctx); err != nil {
+				result = result.Failed(err)
+			} else {
+				// one file at a time, to know the outcome of each
+				one := make(chan FileWithIndex, 1)
+				one <- file
+				close(one)
+				for err := range c.Download(one, creator, post) {
+					result = result.Failed(err)
+				}
+			}
+			results <- result
+		}
+	}()
+	return results
+}
+
+func (c *contextDownloader) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	if err := Cancelled(ctx); err != nil {
+		return nil, err
+	}
+	return c.Get(url)
+}
+
+// State records finished posts, so later runs can skip them
+type State interface {
+	// PostDone report whether the post was completely downloaded before
+	PostDone(post Post) (bool, error)
+	// MarkPost record that the post was completely downloaded
+	MarkPost(post Post) error
+}
+
+// Exporter writes a post once its files are downloaded, e.g. as a post.json sidecar.
+// A Downloader implementing it is called after every post
+type Exporter interface {
+	Export(creator Creator, post Post, files []FileResult) error
+}
+
+// CancelledError is returned when a run is stopped through its context
+type CancelledError struct {
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("cancelled: %s", e.Err)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// Cancelled return a CancelledError if ctx is done, otherwise nil
+func Cancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &CancelledError{Err: err}
+	}
+	return nil
+}
+
 type Log interface {
 	Printf(format string, v ...interface{})
 	Print(s string)
@@ -40,11 +133,18 @@ type PostFilter func(i int, post Post) bool
 
 type AttachmentFilter func(i int, attachment File) bool
 
+// PostCutoff report whether the post is past the cutoff. The post list is paged newest first, so paging stops at the
+// first post past the cutoff, and it and the older posts are left out. A cutoff must hold for every post older than
+// one it holds for, e.g. a release date: an incremental update then costs one request instead of the whole list
+type PostCutoff func(post Post) bool
+
 type Option func(*Kemono)
 
 type Kemono struct {
 	// kemono or coomer ...
 	Site string
+	// origin of the api and files, e.g. https://kemono.su, default https://<Site>.su
+	baseURL string
 	//download Banner
 	Banner bool
 	// All Creator
@@ -59,6 +159,21 @@ type Kemono struct {
 	// Attachment filter map[creator(<service>:<id>)][]AttachmentFilter
 	attachmentFilters map[string][]AttachmentFilter
 
+	// Post cutoff map[creator(<service>:<id>)][]PostCutoff
+	postCutoffs map[string][]PostCutoff
+
+	// posts fetched one by one instead of the post list, map[creator(<service>:<id>)][]<post id>
+	userPosts map[string][]string
+
+	// creators (<service>:<id>) selected with all their posts, their selected posts are not fetched one by one
+	allPosts map[string]bool
+
+	// channels of discord servers to fetch, map[<server id>][]<channel id>, every channel if none
+	discordChannels map[string][]string
+
+	// creators (<service>:<id>) whose own filters replace the filters of every creator
+	filterOverrides map[string]bool
+
 	// Select a specific creator
 	// If not specified, all creators will be selected
 	users []Creator
@@ -66,11 +181,31 @@ type Kemono struct {
 	// downloader
 	Downloader Downloader
 
+	// download state, if set, finished posts are skipped
+	state State
+
+	// report of the last run
+	report *Report
+
+	// posts downloaded at the same time, 1 keeps the post order
+	postConcurrency int
+
+	// dry run, build a plan instead of downloading
+	dryRun bool
+
+	// download the files referenced in the content of posts too
+	contentFiles bool
+
+	// plan of the last dry run
+	plan *Plan
+
 	log Log
 
 	retry int
 
 	retryInterval time.Duration
+
+	retryPolicy utils.RetryPolicy
 }
 
 func NewKemono(options ...Option) *Kemono {
@@ -79,8 +214,14 @@ func NewKemono(options ...Option) *Kemono {
 		Banner:            true,
 		postFilters:       make(map[string][]PostFilter),
 		attachmentFilters: make(map[string][]AttachmentFilter),
+		postCutoffs:       make(map[string][]PostCutoff),
+		filterOverrides:   make(map[string]bool),
+		userPosts:         make(map[string][]string),
+		allPosts:          make(map[string]bool),
+		discordChannels:   make(map[string][]string),
 		retry:             3,
 		retryInterval:     5 * time.Second,
+		postConcurrency:   1,
 	}
 	for _, option := range options {
 		option(k)
@@ -92,6 +233,15 @@ func NewKemono(options ...Option) *Kemono {
 	if k.log == nil {
 		k.log = &DefaultLog{log: log.New(os.Stdout, "", log.LstdFlags)}
 	}
+	if k.retryPolicy == nil {
+		k.retryPolicy = utils.ExponentialBackoff{
+			Base:          k.retryInterval,
+			Max:           maxRetryInterval,
+			Jitter:        0.2,
+			ConnRetries:   k.retry - 1,
+			StatusRetries: k.retry - 1,
+		}
+	}
 	return k
 }
 
@@ -102,6 +252,13 @@ func WithDomain(web string) Option {
 	}
 }
 
+// WithBaseURL Set the origin of every api and file request, e.g. a mirror or http://127.0.0.1:8080
+func WithBaseURL(baseURL string) Option {
+	return func(k *Kemono) {
+		k.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
 func WithBanner(banner bool) Option {
 	return func(k *Kemono) {
 		k.Banner = banner
@@ -119,16 +276,8 @@ func WithCreators(creators []Creator) Option {
 func WithUsers(user ...Creator) Option {
 	return func(k *Kemono) {
 		for _, u := range user {
-			exist := false
-			for _, c := range k.users {
-				if c.Service == u.Service && c.Id == u.Id {
-					exist = true
-					break
-				}
-			}
-			if !exist {
-				k.users = append(k.users, u)
-			}
+			k.addUser(u)
+			k.allPosts[u.PairString()] = true
 		}
 	}
 }
@@ -141,20 +290,31 @@ func WithUsersPair(serviceIdPairs ...string) Option {
 			return
 		}
 		for i := 0; i < len(serviceIdPairs); i += 2 {
-			exist := false
-			for _, c := range k.users {
-				if c.Service == serviceIdPairs[i] && c.Id == serviceIdPairs[i+1] {
-					exist = true
-					break
-				}
-			}
-			if !exist {
-				k.users = append(k.users, NewCreator(serviceIdPairs[i], serviceIdPairs[i+1]))
-			}
+			u := NewCreator(serviceIdPairs[i], serviceIdPairs[i+1])
+			k.addUser(u)
+			k.allPosts[u.PairString()] = true
 		}
 	}
 }
 
+// WithUserPosts Select the posts of a creator, they are fetched each with a single request instead of paging
+// through the post list of the creator. A creator also selected by WithUsers or WithUsersPair gets all its posts
+func WithUserPosts(creator Creator, ids ...string) Option {
+	return func(k *Kemono) {
+		k.addUser(creator)
+		k.userPosts[creator.PairString()] = append(k.userPosts[creator.PairString()], ids...)
+	}
+}
+
+// WithDiscordChannels Select a discord server, only the messages of the channels are fetched, or of every channel
+// of the server if none is given
+func WithDiscordChannels(server string, channels ...string) Option {
+	return func(k *Kemono) {
+		k.addUser(NewCreator("discord", server))
+		k.discordChannels[server] = append(k.discordChannels[server], channels...)
+	}
+}
+
 // SetDownloader set Downloader
 func SetDownloader(downloader Downloader) Option {
 	return func(k *Kemono) {
@@ -162,6 +322,31 @@ func SetDownloader(downloader Downloader) Option {
 	}
 }
 
+// WithState skip the posts recorded as finished in state, and record the new ones
+func WithState(state State) Option {
+	return func(k *Kemono) {
+		k.state = state
+	}
+}
+
+// WithPostConcurrency set how many posts are downloaded at the same time, default 1.
+// Files of all posts share the worker pool of the downloader, more than 1 does not keep the post order
+func WithPostConcurrency(n int) Option {
+	return func(k *Kemono) {
+		if n < 1 {
+			n = 1
+		}
+		k.postConcurrency = n
+	}
+}
+
+// WithDryRun fetch and filter the posts, and build a plan of what would be downloaded, without downloading or writing anything
+func WithDryRun(dryRun bool) Option {
+	return func(k *Kemono) {
+		k.dryRun = dryRun
+	}
+}
+
 // SetLog set log
 func SetLog(log Log) Option {
 	return func(k *Kemono) {
@@ -176,13 +361,20 @@ func SetRetry(retry int) Option {
 	}
 }
 
-// SetRetryInterval set retry interval
+// SetRetryInterval set the first retry interval, it doubles after each failure
 func SetRetryInterval(retryInterval time.Duration) Option {
 	return func(k *Kemono) {
 		k.retryInterval = retryInterval
 	}
 }
 
+// WithRetryPolicy set the retry policy of the post list, it replaces SetRetry and SetRetryInterval
+func WithRetryPolicy(policy utils.RetryPolicy) Option {
+	return func(k *Kemono) {
+		k.retryPolicy = policy
+	}
+}
+
 // WithCreatorFilter Creator filter
 func WithCreatorFilter(filter ...CreatorFilter) Option {
 	return func(k *Kemono) {
@@ -203,6 +395,19 @@ func WithUserPostFilter(creator Creator, filter ...PostFilter) Option {
 	}
 }
 
+// WithPostCutoff stop paging the post lists at the first post past a cutoff, see PostCutoff
+func WithPostCutoff(cutoff ...PostCutoff) Option {
+	return func(k *Kemono) {
+		k.postCutoffs["*"] = append(k.postCutoffs["*"], cutoff...)
+	}
+}
+
+func WithUserPostCutoff(creator Creator, cutoff ...PostCutoff) Option {
+	return func(k *Kemono) {
+		k.postCutoffs[creator.PairString()] = append(k.postCutoffs[creator.PairString()], cutoff...)
+	}
+}
+
 // WithAttachmentFilter Attachment filter
 func WithAttachmentFilter(filter ...AttachmentFilter) Option {
 	return func(k *Kemono) {
@@ -216,67 +421,121 @@ func WithUserAttachmentFilter(creator Creator, filter ...AttachmentFilter) Optio
 	}
 }
 
+// WithContentFiles download the files referenced in the content of posts too, see ContentFiles. They are added
+// after the attachments, and go through the attachment filters and the save path like them
+func WithContentFiles(contentFiles bool) Option {
+	return func(k *Kemono) {
+		k.contentFiles = contentFiles
+	}
+}
+
+// WithUserFilterOverride the post and attachment filters and post cutoffs of every creator (WithPostFilter,
+// WithAttachmentFilter, WithPostCutoff) are not applied to creator, only its own ones (WithUserPostFilter,
+// WithUserAttachmentFilter, WithUserPostCutoff)
+func WithUserFilterOverride(creator Creator) Option {
+	return func(k *Kemono) {
+		k.filterOverrides[creator.PairString()] = true
+	}
+}
+
+// BaseURL return the origin of the api and files
+func (k *Kemono) BaseURL() string {
+	if k.baseURL != "" {
+		return k.baseURL
+	}
+	return fmt.Sprintf("https://%s.su", k.Site)
+}
+
 // Start fetch and download
 func (k *Kemono) Start() error {
+	return k.StartContext(context.Background())
+}
+
+// StartContext fetch and download, stops when ctx is done and returns a *CancelledError
+func (k *Kemono) StartContext(ctx context.Context) error {
+	k.report = newReport(k.Site)
+	defer k.report.finish()
+	if k.dryRun {
+		k.plan = &Plan{Site: k.Site}
+	}
+
 	// initialize the creators
 	if len(k.creators) == 0 {
 		// fetch creators from kemono
-		cs, err := k.FetchCreators()
+		cs, err := k.FetchCreatorsContext(ctx)
 		if err != nil {
+			k.report.addError(err)
 			return err
 		}
 		k.creators = cs
 	}
 
-	//find creators
-	if len(k.users) != 0 {
-		var creators []Creator
-		for _, user := range k.users {
-			c, ok := FindCreator(k.creators, user.Id, user.Service)
+	k.users = k.selectCreators(k.creators, k.users)
+
+	// start download, posts are fetched and downloaded at the same time
+	k.log.Printf("Start download %d creators", len(k.users))
+	jobs := make(chan postJob, postQueueSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		fetchErr <- k.fetchStage(ctx, k.users, jobs, k.fetchAll)
+	}()
+	k.downloadStage(ctx, jobs)
+	if err := <-fetchErr; err != nil {
+		return err
+	}
+	return Cancelled(ctx)
+}
+
+// selectCreators find the users in creators, all of them if users is empty, and filter them
+func (k *Kemono) selectCreators(creators []Creator, users []Creator) []Creator {
+	selected := creators
+	if len(users) != 0 {
+		selected = nil
+		for _, user := range users {
+			c, ok := FindCreator(creators, user.Id, user.Service)
 			if !ok {
 				k.log.Printf("Creator %s:%s not found", user.Service, user.Id)
+				k.report.addError(fmt.Errorf("creator %s:%s not found", user.Service, user.Id))
 				continue
 			}
-			creators = append(creators, c)
+			selected = append(selected, c)
 		}
-		k.users = creators
-	} else {
-		k.users = k.creators
 	}
+	return k.FilterCreators(selected)
+}
 
-	// Filter selected creators
-	k.users = k.FilterCreators(k.users)
-
-	// start download
-	k.log.Printf("Start download %d creators", len(k.users))
-	for _, creator := range k.users {
-		// fetch posts
-		posts, err := k.FetchPosts(creator.Service, creator.Id)
-		if err != nil {
-			return err
-		}
-		// filter posts
-		posts = k.FilterPosts(posts)
-
-		// filter attachments
-		for i, post := range posts {
-			// download banner if banner is true or file is not image
-			if (k.Banner || !isImage(filepath.Ext(post.File.Name))) && post.File.Path != "" {
-				res := make([]File, len(post.Attachments)+1)
-				copy(res[1:], post.Attachments)
-				res[0] = post.File
-				post.Attachments = res
-			}
-			posts[i].Attachments = k.FilterAttachments(fmt.Sprintf("%s:%s", post.Service, post.User), post.Attachments)
+// addUser select the creator, once
+func (k *Kemono) addUser(u Creator) {
+	for _, c := range k.users {
+		if c.Service == u.Service && c.Id == u.Id {
+			return
 		}
+	}
+	k.users = append(k.users, u)
+}
 
-		// download posts
-		err = k.DownloadPosts(creator, posts)
-		if err != nil {
-			return err
-		}
+// onlyPosts return the posts selected by WithUserPosts, ok is false if the creator is selected with all its posts
+func (k *Kemono) onlyPosts(creator Creator) (ids []string, ok bool) {
+	ids, ok = k.userPosts[creator.PairString()]
+	return ids, ok && !k.allPosts[creator.PairString()]
+}
+
+// reportError log err and add it to the report of the run, if any
+func (k *Kemono) reportError(err error) {
+	k.log.Printf("%s", err)
+	if k.report != nil {
+		k.report.addError(err)
 	}
-	return nil
+}
+
+// Report return the report of the last run, nil if it has not started
+func (k *Kemono) Report() *Report {
+	return k.report
+}
+
+// Plan return the plan of the last dry run, nil if it was not a dry run
+func (k *Kemono) Plan() *Plan {
+	return k.plan
 }
 
 func (k *Kemono) addCreatorFilter(filter ...CreatorFilter) {
@@ -284,11 +543,36 @@ func (k *Kemono) addCreatorFilter(filter ...CreatorFilter) {
 }
 
 func (k *Kemono) addPostFilter(filter ...PostFilter) {
-	k.postFilters["*"] = append(k.postFilters["*"], filter...)
+	k.addUserPostFilter("*", filter...)
 }
 
+// addUserPostFilter add the filters of user, and the cutoffs of the date-monotonic ones so paging stops with them
 func (k *Kemono) addUserPostFilter(user string, filter ...PostFilter) {
 	k.postFilters[user] = append(k.postFilters[user], filter...)
+	for _, f := range filter {
+		if cutoff := filterCutoff(f); cutoff != nil {
+			k.postCutoffs[user] = append(k.postCutoffs[user], cutoff)
+		}
+	}
+}
+
+// filterCutoff the cutoff of a filter which drops every post older than one it drops, e.g. ReleaseDateAfterFilter,
+// nil for other filters. The filter is called once with index -1 and a zero post which it answers with its cutoff
+func filterCutoff(filter PostFilter) PostCutoff {
+	var cutoff PostCutoff
+	filter(-1, Post{cutoff: &cutoff})
+	return cutoff
+}
+
+// withCutoff a filter answering the probe of filterCutoff with cutoff
+func withCutoff(filter PostFilter, cutoff PostCutoff) PostFilter {
+	return func(i int, post Post) bool {
+		if post.cutoff != nil {
+			*post.cutoff = cutoff
+			return true
+		}
+		return filter(i, post)
+	}
 }
 
 func (k *Kemono) addAttachmentFilter(filter ...AttachmentFilter) {
@@ -309,12 +593,15 @@ func (k *Kemono) filterCreator(i int, creator Creator) bool {
 }
 
 func (k *Kemono) filterPost(i int, post Post) bool {
-	for _, filter := range k.postFilters["*"] {
-		if !filter(i, post) {
-			return false
+	user := fmt.Sprintf("%s:%s", post.Service, post.User)
+	if !k.filterOverrides[user] {
+		for _, filter := range k.postFilters["*"] {
+			if !filter(i, post) {
+				return false
+			}
 		}
 	}
-	for _, filter := range k.postFilters[fmt.Sprintf("%s:%s", post.Service, post.User)] {
+	for _, filter := range k.postFilters[user] {
 		if !filter(i, post) {
 			return false
 		}
@@ -323,9 +610,11 @@ func (k *Kemono) filterPost(i int, post Post) bool {
 }
 
 func (k *Kemono) filterAttachment(user string, i int, attachment File) bool {
-	for _, filter := range k.attachmentFilters["*"] {
-		if !filter(i, attachment) {
-			return false
+	if !k.filterOverrides[user] {
+		for _, filter := range k.attachmentFilters["*"] {
+			if !filter(i, attachment) {
+				return false
+			}
 		}
 	}
 	for _, filter := range k.attachmentFilters[user] {
@@ -346,6 +635,24 @@ func (k *Kemono) FilterCreators(creators []Creator) []Creator {
 	return filteredCreators
 }
 
+// pastCutoff report whether the post is past a cutoff of its creator
+func (k *Kemono) pastCutoff(post Post) bool {
+	user := fmt.Sprintf("%s:%s", post.Service, post.User)
+	if !k.filterOverrides[user] {
+		for _, cutoff := range k.postCutoffs["*"] {
+			if cutoff(post) {
+				return true
+			}
+		}
+	}
+	for _, cutoff := range k.postCutoffs[user] {
+		if cutoff(post) {
+			return true
+		}
+	}
+	return false
+}
+
 func (k *Kemono) FilterPosts(posts []Post) []Post {
 	var filteredPosts []Post
 	for i, post := range posts {
@@ -366,17 +673,33 @@ func (k *Kemono) FilterAttachments(user string, attachments []File) []File {
 	return filteredAttachments
 }
 
-// ReleaseDateFilter A Post  filter that filters posts with release date
+// ReleaseDateFilter A Post  filter that filters posts with release date,
+// the posts are released in the order of the list, so paging stops at from, see ReleaseDateCutoff
 func ReleaseDateFilter(from, to time.Time) PostFilter {
-	return func(i int, post Post) bool {
+	return withCutoff(func(i int, post Post) bool {
 		return post.Published.After(from) && post.Published.Before(to)
-	}
+	}, ReleaseDateCutoff(from))
 }
 
-// ReleaseDateAfterFilter A Post  filter that filters posts with release date after
+// ReleaseDateAfterFilter A Post  filter that filters posts with release date after,
+// the posts are released in the order of the list, so paging stops at from, see ReleaseDateCutoff
 func ReleaseDateAfterFilter(from time.Time) PostFilter {
-	return func(i int, post Post) bool {
+	return withCutoff(func(i int, post Post) bool {
 		return post.Published.After(from)
+	}, ReleaseDateCutoff(from))
+}
+
+// ReleaseDateCutoff A PostCutoff at the posts released at or before from, posts without a release date are not
+func ReleaseDateCutoff(from time.Time) PostCutoff {
+	return func(post Post) bool {
+		return !post.Published.IsZero() && !post.Published.After(from)
+	}
+}
+
+// SincePostCutoff A PostCutoff at the post with the id, only the posts newer than it are fetched
+func SincePostCutoff(id string) PostCutoff {
+	return func(post Post) bool {
+		return post.Id == id
 	}
 }
 
@@ -0,0 +1,962 @@
+package kemonotest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/kemono/kemonotest"
+	"github.com/elvis972602/kemono-scraper/state"
+)
+
+type nopLog struct{}
+
+func (nopLog) Printf(format string, v ...interface{}) {}
+func (nopLog) Print(s string)                         {}
+func (nopLog) SetStatus(s []string)                   {}
+
+// newKemono create a Kemono downloading from srv into dir
+func newKemono(srv *kemonotest.Server, dir string, options ...kemono.Option) *kemono.Kemono {
+	d := downloader.NewDownloader(
+		downloader.BaseURL(srv.URL),
+		downloader.Async(true),
+		downloader.MaxConcurrent(5),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.Retry(3),
+		downloader.RetryInterval(0),
+		downloader.SetLog(nopLog{}),
+		downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, creator.Id, post.Id, attachment.Name)
+		}),
+	)
+	options = append([]kemono.Option{
+		kemono.WithBaseURL(srv.URL),
+		kemono.SetDownloader(d),
+		kemono.SetLog(nopLog{}),
+		kemono.SetRetryInterval(0),
+	}, options...)
+	return kemono.NewKemono(options...)
+}
+
+type fixture struct {
+	// path relative to the download directory
+	local string
+	data  []byte
+	file  kemono.File
+}
+
+// addCreator add a creator with n posts, the first files posts have one attachment each
+func addCreator(srv *kemonotest.Server, id string, n, files int) []fixture {
+	srv.AddCreator(kemono.Creator{Id: id, Name: "creator " + id, Service: "fanbox", Updated: kemono.Timestamp{Time: time.Now()}})
+	var fixtures []fixture
+	for i := 0; i < n; i++ {
+		post := kemono.PostRaw{
+			Id:        fmt.Sprintf("%d", n-i),
+			Service:   "fanbox",
+			User:      id,
+			Title:     fmt.Sprintf("post %d", n-i),
+			Published: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n-i).Format("2006-01-02T15:04:05"),
+		}
+		if i < files {
+			name := fmt.Sprintf("%s-%d.png", id, i)
+			data := bytes.Repeat([]byte(name), 1000+i)
+			file := srv.AddFile(name, data)
+			post.Attachments = []kemono.File{file}
+			fixtures = append(fixtures, fixture{local: filepath.Join(id, post.Id, name), data: data, file: file})
+		}
+		srv.AddPost(post)
+	}
+	return fixtures
+}
+
+func checkFiles(t *testing.T, dir string, fixtures []fixture) {
+	t.Helper()
+	for _, f := range fixtures {
+		got, err := os.ReadFile(filepath.Join(dir, f.local))
+		if err != nil {
+			t.Errorf("read %s: %s", f.local, err)
+			continue
+		}
+		if !bytes.Equal(got, f.data) {
+			t.Errorf("%s differs from source", f.local)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := append(addCreator(srv, "1", 120, 3), addCreator(srv, "2", 2, 2)...)
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1", "fanbox", "2"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+
+	// 120 posts, 3 pages and one empty page
+	if pages := pageRequests(srv, "1"); pages != 4 {
+		t.Errorf("expected 4 page requests, got %d", pages)
+	}
+
+	summary := k.Report().Summary
+	if summary.Posts != 122 || summary.Downloaded != 5 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	// the second run finds every file on disk
+	k = newKemono(srv, dir, kemono.WithUsersPair("fanbox", "2"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Exists != 2 || summary.Downloaded != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+// pageRequests count the post list requests of the creator
+func pageRequests(srv *kemonotest.Server, id string) int {
+	pages := 0
+	for _, r := range srv.Requests() {
+		if r == "/api/v1/fanbox/user/"+id {
+			pages++
+		}
+	}
+	return pages
+}
+
+func TestStart_PostCutoff(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	addCreator(srv, "1", 120, 0)
+
+	// posts 111 to 120 are released after post 110
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 110)
+	k := newKemono(srv, t.TempDir(), kemono.WithUsersPair("fanbox", "1"), kemono.WithPostCutoff(kemono.ReleaseDateCutoff(from)))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Posts != 10 {
+		t.Errorf("expected 10 posts, got %+v", summary)
+	}
+	if pages := pageRequests(srv, "1"); pages != 1 {
+		t.Errorf("expected 1 page request, got %d", pages)
+	}
+
+	posts, err := k.FetchPostsUntil("fanbox", "1", kemono.SincePostCutoff("60"))
+	if err != nil {
+		t.Fatalf("fetch posts failed: %s", err)
+	}
+	if len(posts) != 60 || posts[0].Id != "120" || posts[59].Id != "61" {
+		t.Errorf("expected posts 120 to 61, got %d posts", len(posts))
+	}
+	if pages := pageRequests(srv, "1"); pages != 3 {
+		t.Errorf("expected 2 more page requests, got %d", pages-1)
+	}
+
+	// the caller stops paging after the first page
+	var got []kemono.Post
+	err = k.FetchPostPages("fanbox", "1", func(page []kemono.Post) bool {
+		got = append(got, page...)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("fetch post pages failed: %s", err)
+	}
+	if len(got) != kemonotest.PageSize {
+		t.Errorf("expected %d posts, got %d", kemonotest.PageSize, len(got))
+	}
+	if pages := pageRequests(srv, "1"); pages != 4 {
+		t.Errorf("expected 1 more page request, got %d", pages-3)
+	}
+}
+
+func TestStart_FilterCutoff(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 110)
+	for name, opt := range map[string]kemono.Option{
+		"global": kemono.WithPostFilter(kemono.ReleaseDateAfterFilter(from)),
+		"user":   kemono.WithUserPostFilter(kemono.NewCreator("fanbox", "1"), kemono.ReleaseDateAfterFilter(from)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := kemonotest.NewServer()
+			defer srv.Close()
+			addCreator(srv, "1", 120, 0)
+
+			// the date filter stops paging like ReleaseDateCutoff
+			k := newKemono(srv, t.TempDir(), kemono.WithUsersPair("fanbox", "1"), opt)
+			if err := k.Start(); err != nil {
+				t.Fatalf("start failed: %s", err)
+			}
+			if summary := k.Report().Summary; summary.Posts != 10 {
+				t.Errorf("expected 10 posts, got %+v", summary)
+			}
+			if pages := pageRequests(srv, "1"); pages != 1 {
+				t.Errorf("expected 1 page request, got %d", pages)
+			}
+		})
+	}
+}
+
+func TestStart_SinglePost(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 120, 3)
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUserPosts(kemono.NewCreator("fanbox", "1"), "119", "5"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures[1:2])
+	if summary := k.Report().Summary; summary.Posts != 2 || summary.Downloaded != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if pages := pageRequests(srv, "1"); pages != 0 {
+		t.Errorf("expected no page request, got %d", pages)
+	}
+
+	post, err := k.FetchPost("fanbox", "1", "120")
+	if err != nil {
+		t.Fatalf("fetch post failed: %s", err)
+	}
+	if post.Id != "120" || len(post.Attachments) != 1 || post.Published.IsZero() {
+		t.Errorf("unexpected post: %+v", post)
+	}
+	if _, err := k.FetchPost("fanbox", "1", "121"); err == nil {
+		t.Errorf("expected an error for a missing post")
+	}
+
+	// a missing post is reported, the other posts and creators are still downloaded
+	addCreator(srv, "2", 1, 1)
+	k = newKemono(srv, t.TempDir(),
+		kemono.WithUserPosts(kemono.NewCreator("fanbox", "1"), "119", "404", "118"),
+		kemono.WithUserPosts(kemono.NewCreator("fanbox", "2"), "1"),
+	)
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if report := k.Report(); report.Summary.Posts != 3 || len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "404") {
+		t.Errorf("expected 3 posts and the error of post 404, got %+v %q", report.Summary, report.Errors)
+	}
+
+	// a creator also selected with all its posts gets all of them
+	k = newKemono(srv, t.TempDir(), kemono.WithUserPosts(kemono.NewCreator("fanbox", "1"), "119"), kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Posts != 120 {
+		t.Errorf("expected 120 posts, got %+v", summary)
+	}
+}
+
+func TestStart_Discord(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "9", Name: "server", Service: "discord", Updated: kemono.Timestamp{Time: time.Now()}})
+	message := func(channel string, i int) kemono.DiscordMessage {
+		return kemono.DiscordMessage{
+			Id:        fmt.Sprintf("%s-%d", channel, i),
+			Author:    kemono.DiscordAuthor{Id: "a", Username: "author"},
+			Server:    "9",
+			Channel:   channel,
+			Content:   fmt.Sprintf("message %d\nsecond line", i),
+			Published: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i).Format("2006-01-02T15:04:05"),
+		}
+	}
+	var general []kemono.DiscordMessage
+	for i := 160; i > 0; i-- {
+		general = append(general, message("general", i))
+	}
+	srv.AddDiscordChannel("9", kemono.DiscordChannel{Id: "general", Name: "general"}, general...)
+	data := bytes.Repeat([]byte("art"), 1000)
+	art := message("art", 1)
+	art.Attachments = []kemono.File{srv.AddFile("art.png", data)}
+	srv.AddDiscordChannel("9", kemono.DiscordChannel{Id: "art", Name: "art"}, art)
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithDiscordChannels("9"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, []fixture{{local: filepath.Join("9", "art-1", "art.png"), data: data}})
+	if summary := k.Report().Summary; summary.Posts != 161 || summary.Downloaded != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	// a short page is the last one
+	pages := 0
+	for _, r := range srv.Requests() {
+		if r == "/api/v1/discord/channel/general" {
+			pages++
+		}
+	}
+	if pages != 2 {
+		t.Errorf("expected 2 page requests, got %d", pages)
+	}
+
+	// only the given channel, without looking up the channels of the server
+	k = newKemono(srv, t.TempDir(), kemono.WithDiscordChannels("9", "art"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Posts != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	lookups := 0
+	for _, r := range srv.Requests() {
+		if r == "/api/v1/discord/channel/lookup/9" {
+			lookups++
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("expected 1 channel lookup, got %d", lookups)
+	}
+
+	post, err := art.Post()
+	if err != nil {
+		t.Fatalf("map message failed: %s", err)
+	}
+	if post.Service != "discord" || post.User != "9" || post.Title != "author message 1" || post.Published.IsZero() {
+		t.Errorf("unexpected post: %+v", post)
+	}
+}
+
+func TestStart_ContentFiles(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+	attachment := srv.AddFile("a.png", []byte("attachment"))
+	inline := srv.AddFile("b.png", []byte("inline image"))
+	linked := srv.AddFile("c.zip", []byte("linked file"))
+	srv.AddPost(kemono.PostRaw{Id: "1", Service: "fanbox", User: "1", Title: "post 1",
+		Attachments: []kemono.File{attachment},
+		Content: `<p><img src="/data` + attachment.Path + `"><img src="/data` + inline.Path + `"></p>` +
+			`<p><a href="https://kemono.su/data` + linked.Path + `?f=doc.zip">doc</a> <a href="https://example.com/data/ab/cd/x.png">other</a></p>`,
+	})
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"), kemono.WithContentFiles(true))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, []fixture{
+		{local: filepath.Join("1", "1", "a.png"), data: []byte("attachment")},
+		{local: filepath.Join("1", "1", filepath.Base(inline.Path)), data: []byte("inline image")},
+		{local: filepath.Join("1", "1", "doc.zip"), data: []byte("linked file")},
+	})
+	if summary := k.Report().Summary; summary.Downloaded != 3 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestStart_PostMetadata(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+	srv.AddPost(kemono.PostRaw{Id: "1", Service: "fanbox", User: "1", Title: "post 1",
+		Added: "2023-02-01T00:00:00", Published: "not a date",
+		Embed: kemono.Embed{URL: "https://example.com/video", Subject: "video"},
+		Tags:  kemono.Tags{"a", "b"},
+		Poll:  &kemono.Poll{Title: "poll", Choices: []kemono.PollChoice{{Text: "yes", Votes: 2}}},
+	})
+
+	k := newKemono(srv, t.TempDir(), kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if errs := k.Report().Errors; len(errs) != 1 || !strings.Contains(errs[0], `parse published date "not a date"`) {
+		t.Errorf("expected the date error in the report, got %v", errs)
+	}
+
+	posts, err := k.FetchPosts("fanbox", "1")
+	if err != nil {
+		t.Fatalf("fetch posts failed: %s", err)
+	}
+	post := posts[0]
+	if !post.Published.Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the added date as published date, got %s", post.Published)
+	}
+	if post.Embed.Subject != "video" || len(post.Tags) != 2 || post.Poll == nil || post.Poll.Choices[0].Votes != 2 {
+		t.Errorf("unexpected metadata: %+v", post)
+	}
+
+	// tags may be a postgres array literal
+	var raw kemono.PostRaw
+	if err := json.Unmarshal([]byte(`{"id":"2","tags":"{a,\"b c\"}","embed":{}}`), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Tags) != 2 || raw.Tags[1] != "b c" || !raw.Embed.IsZero() {
+		t.Errorf("unexpected post: %+v", raw)
+	}
+
+	// a post without embed may have an empty array or string instead of an object
+	for _, embed := range []string{`[]`, `""`, `null`} {
+		var raw kemono.PostRaw
+		if err := json.Unmarshal([]byte(`{"id":"3","embed":`+embed+`}`), &raw); err != nil {
+			t.Fatalf("embed %s: %s", embed, err)
+		}
+		if raw.Id != "3" || !raw.Embed.IsZero() {
+			t.Errorf("embed %s: unexpected post: %+v", embed, raw)
+		}
+	}
+}
+
+// TestStart_PostConcurrency downloads the posts of several creators at the same time, run it with -race
+func TestStart_PostConcurrency(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	var fixtures []fixture
+	for _, id := range []string{"1", "2", "3"} {
+		fixtures = append(fixtures, addCreator(srv, id, 3, 3)...)
+	}
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1", "fanbox", "2", "fanbox", "3"), kemono.WithPostConcurrency(4))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+	if summary := k.Report().Summary; summary.Posts != 9 || summary.Downloaded != 9 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestStart_UserFilterOverride(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	addCreator(srv, "1", 3, 3)
+	addCreator(srv, "2", 3, 3)
+
+	first := kemono.NumbFilter(func(i int) bool { return i < 1 })
+	dir := t.TempDir()
+	k := newKemono(srv, dir,
+		kemono.WithUsersPair("fanbox", "1", "fanbox", "2"),
+		kemono.WithPostFilter(first),
+		kemono.WithAttachmentFilter(kemono.ExtensionExcludeFilter(".zip")),
+		// creator 2 gets all of its posts, but no png
+		kemono.WithUserFilterOverride(kemono.NewCreator("fanbox", "2")),
+		kemono.WithUserAttachmentFilter(kemono.NewCreator("fanbox", "2"), kemono.ExtensionExcludeFilter(".png")),
+	)
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Posts != 4 || summary.Downloaded != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestStart_Faults(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 4, 4)
+
+	srv.Fail("/api/v1/creators", kemonotest.Fault{Gzip: true})
+	srv.Fail("/api/v1/fanbox/user/1", kemonotest.Fault{Times: 1, Status: http.StatusTooManyRequests, RetryAfter: "0"})
+	srv.Fail("/api/v1/fanbox/user/1", kemonotest.Fault{Gzip: true})
+
+	srv.Fail(fixtures[0].file.Path, kemonotest.Fault{Times: 1, Status: http.StatusTooManyRequests})
+	srv.Fail(fixtures[1].file.Path, kemonotest.Fault{Times: 1, Truncate: 100})
+	srv.Fail(fixtures[2].file.Path, kemonotest.Fault{NoContentLength: true})
+	srv.Fail(fixtures[3].file.Path, kemonotest.Fault{Gzip: true, NoContentLength: true})
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+	if summary := k.Report().Summary; summary.Downloaded != 4 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestStart_Resume(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 1, 1)
+	path := fixtures[0].file.Path
+	srv.Fail(path, kemonotest.Fault{Times: 1, Truncate: 100})
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+
+	// the second request resumes after the bytes received by the first
+	ranges := srv.Ranges(path)
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=100-" {
+		t.Errorf("expected a full request then a ranged one, got %q", ranges)
+	}
+
+	// a range of another version of the file is answered with the whole file
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	req.Header.Set("Range", "bytes=100-")
+	req.Header.Set("If-Range", `"other"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 for a stale If-Range, got %d", resp.StatusCode)
+	}
+}
+
+// basicDownloader a Downloader written before the context methods
+type basicDownloader struct {
+	d kemono.Downloader
+}
+
+func (b basicDownloader) Download(files <-chan kemono.FileWithIndex, creator kemono.Creator, post kemono.Post) <-chan error {
+	return b.d.Download(files, creator, post)
+}
+
+func (b basicDownloader) Get(url string) (*http.Response, error) {
+	return b.d.Get(url)
+}
+
+func (b basicDownloader) WriteContent(creator kemono.Creator, post kemono.Post, content string) error {
+	return b.d.WriteContent(creator, post, content)
+}
+
+func TestDownloadPosts(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 2, 2)
+	srv.Fail(fixtures[1].file.Path, kemonotest.Fault{Status: http.StatusNotFound})
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir)
+	creator := kemono.NewCreator("fanbox", "1")
+	posts, err := k.FetchPosts("fanbox", "1")
+	if err != nil {
+		t.Fatalf("fetch posts failed: %s", err)
+	}
+
+	// the failed file is returned
+	err = k.DownloadPosts(creator, posts)
+	var de *kemono.DownloadError
+	if !errors.As(err, &de) || len(de.Posts) != 1 || de.Posts[0].Id != "1" {
+		t.Fatalf("expected a download error of post 1, got %v", err)
+	}
+	checkFiles(t, dir, fixtures[:1])
+
+	// a Downloader without the context methods
+	d := downloader.NewDownloader(
+		downloader.BaseURL(srv.URL),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.SetLog(nopLog{}),
+		downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, "basic", post.Id, attachment.Name)
+		}),
+	)
+	k = kemono.NewKemono(kemono.WithBaseURL(srv.URL), kemono.SetDownloader(kemono.ContextDownloader(basicDownloader{d: d})), kemono.SetLog(nopLog{}))
+	if err := k.DownloadPosts(creator, posts[:1]); err != nil {
+		t.Fatalf("download posts failed: %s", err)
+	}
+	if summary := k.Report().Summary; summary.Downloaded != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	checkFiles(t, dir, []fixture{{local: filepath.Join("basic", "2", fixtures[0].file.Name), data: fixtures[0].data}})
+}
+
+func TestStartContext_Cancel(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 2, 2)
+	for _, f := range fixtures {
+		srv.Fail(f.file.Path, kemonotest.Fault{Stall: 100})
+	}
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- k.StartContext(ctx)
+	}()
+
+	// cancel once the first bytes of the first file are on disk
+	partial := filepath.Join(dir, fixtures[0].local) + ".tmp"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, err := os.Stat(partial); err == nil && info.Size() == 100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the first file did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	err := <-errCh
+	var ce *kemono.CancelledError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a cancelled error, got %v", err)
+	}
+	// the unfinished file keeps its partial data to resume, no file is complete
+	if info, err := os.Stat(partial); err != nil || info.Size() != 100 {
+		t.Errorf("expected the 100 bytes of the partial file, got %v %v", info, err)
+	}
+	for _, f := range fixtures {
+		if _, err := os.Stat(filepath.Join(dir, f.local)); !os.IsNotExist(err) {
+			t.Errorf("%s should not be saved: %v", f.local, err)
+		}
+	}
+}
+
+func TestStart_Transport(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 1, 1)
+
+	// requests to the default origin are sent to the fake server
+	dir := t.TempDir()
+	d := downloader.NewDownloader(
+		downloader.BaseURL("https://kemono.su"),
+		downloader.WithTransport(srv.Transport()),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.SetLog(nopLog{}),
+		downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, creator.Id, post.Id, attachment.Name)
+		}),
+	)
+	k := kemono.NewKemono(kemono.SetDownloader(d), kemono.SetLog(nopLog{}), kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+}
+
+func TestStart_ReportFailures(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 2, 2)
+	srv.Fail(fixtures[0].file.Path, kemonotest.Fault{Times: 3, Status: http.StatusServiceUnavailable})
+
+	dir := t.TempDir()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	failures := k.Report().Failures()
+	if len(failures) != 1 || failures[0].Path != fixtures[0].file.Path {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+	if r := k.Report().Posts; len(r) != 2 {
+		t.Fatalf("unexpected posts: %+v", r)
+	}
+
+	// retry only the failed file, the post list is not fetched again
+	before := len(srv.Requests())
+	k = newKemono(srv, dir)
+	if err := k.RetryFailed(failures); err != nil {
+		t.Fatalf("retry failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+	for _, r := range srv.Requests()[before:] {
+		if strings.HasPrefix(r, "/api/") {
+			t.Errorf("unexpected api request %s", r)
+		}
+	}
+}
+
+func TestStart_NotFoundNotRetried(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+
+	k := newKemono(srv, t.TempDir(), kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err == nil {
+		t.Fatal("expected an error for a missing post list")
+	}
+	pages := 0
+	for _, r := range srv.Requests() {
+		if r == "/api/v1/fanbox/user/1" {
+			pages++
+		}
+	}
+	if pages != 1 {
+		t.Errorf("404 should not be retried, got %d requests", pages)
+	}
+}
+
+func TestStart_StatePartialPost(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+	image, archive := srv.AddFile("a.png", []byte("image")), srv.AddFile("b.zip", []byte("archive"))
+	srv.AddPost(
+		kemono.PostRaw{Id: "2", Service: "fanbox", User: "1", Title: "post 2", Attachments: []kemono.File{image, archive}},
+		kemono.PostRaw{Id: "1", Service: "fanbox", User: "1", Title: "post 1", Attachments: []kemono.File{image}},
+	)
+
+	dir := t.TempDir()
+	db, err := state.Open(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the zip of post 2 is left out by the filter, only post 1 is done
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"), kemono.WithState(db), kemono.WithAttachmentFilter(kemono.ExtensionFilter(".png")))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	for id, want := range map[string]bool{"1": true, "2": false} {
+		if done, err := db.PostDone(kemono.Post{Id: id, Service: "fanbox", User: "1"}); err != nil || done != want {
+			t.Errorf("post %s: expected done %v, got %v %v", id, want, done, err)
+		}
+	}
+
+	// without the filter the zip is downloaded on the next run
+	k = newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"), kemono.WithState(db))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	checkFiles(t, dir, []fixture{{local: filepath.Join("1", "2", "b.zip"), data: []byte("archive")}})
+	// post 1 is skipped, but still reported
+	if summary := k.Report().Summary; summary.Posts != 2 || summary.SkippedPosts != 1 || summary.Downloaded != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+// contentDownloader count the contents written, and fail those of the posts in fail
+type contentDownloader struct {
+	kemono.Downloader
+	lock   sync.Mutex
+	writes map[string]int
+	fail   map[string]bool
+}
+
+func (c *contentDownloader) WriteContent(creator kemono.Creator, post kemono.Post, content string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writes[post.Id]++
+	if c.fail[post.Id] {
+		return fmt.Errorf("write content of post %s failed", post.Id)
+	}
+	return nil
+}
+
+func TestStart_StateTextPost(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+	srv.AddPost(
+		kemono.PostRaw{Id: "2", Service: "fanbox", User: "1", Title: "post 2", Content: "<p>text only</p>"},
+		kemono.PostRaw{Id: "1", Service: "fanbox", User: "1", Title: "post 1", Content: "<p>content fails</p>"},
+	)
+
+	dir := t.TempDir()
+	db, err := state.Open(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	d := &contentDownloader{writes: make(map[string]int), fail: map[string]bool{"1": true}}
+	run := func() {
+		k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"), kemono.WithState(db))
+		d.Downloader = k.Downloader
+		k.Downloader = d
+		if err := k.Start(); err != nil {
+			t.Fatalf("start failed: %s", err)
+		}
+	}
+	run()
+	for id, want := range map[string]bool{"1": false, "2": true} {
+		if done, err := db.PostDone(kemono.Post{Id: id, Service: "fanbox", User: "1"}); err != nil || done != want {
+			t.Errorf("post %s: expected done %v, got %v %v", id, want, done, err)
+		}
+	}
+
+	// the text only post is skipped, the post whose content failed is tried again
+	run()
+	if d.writes["2"] != 1 || d.writes["1"] != 2 {
+		t.Errorf("unexpected content writes: %v", d.writes)
+	}
+}
+
+func TestStart_Store(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	srv.AddCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox"})
+	data := bytes.Repeat([]byte("shared"), 1000)
+	file := srv.AddFile("shared.png", data)
+	for _, id := range []string{"1", "2"} {
+		srv.AddPost(kemono.PostRaw{Id: id, Service: "fanbox", User: "1", Title: "post " + id, Attachments: []kemono.File{file}})
+	}
+
+	dir := t.TempDir()
+	store, err := downloader.NewStore(filepath.Join(dir, "store"), downloader.LinkHard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := downloader.NewDownloader(
+		downloader.BaseURL(srv.URL),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.WithStore(store),
+		downloader.SetLog(nopLog{}),
+		downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, creator.Id, post.Id, attachment.Name)
+		}),
+	)
+	k := kemono.NewKemono(kemono.WithBaseURL(srv.URL), kemono.SetDownloader(d), kemono.SetLog(nopLog{}), kemono.WithUsersPair("fanbox", "1"))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+
+	fixtures := []fixture{
+		{local: filepath.Join("1", "1", "shared.png"), data: data},
+		{local: filepath.Join("1", "2", "shared.png"), data: data},
+	}
+	checkFiles(t, dir, fixtures)
+	if summary := k.Report().Summary; summary.Downloaded != 1 || summary.Linked != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	downloads := 0
+	for _, r := range srv.Requests() {
+		if strings.HasSuffix(r, file.Path) {
+			downloads++
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("expected 1 download, got %d", downloads)
+	}
+	a, _ := os.Stat(filepath.Join(dir, fixtures[0].local))
+	b, _ := os.Stat(filepath.Join(dir, fixtures[1].local))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Errorf("save paths should be hardlinks of the same blob")
+	}
+}
+
+func TestStart_DryRun(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 3, 3)
+
+	dir := t.TempDir()
+	// the first file is already downloaded
+	existing := filepath.Join(dir, fixtures[0].local)
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, fixtures[0].data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := downloader.NewDownloader(
+		downloader.BaseURL(srv.URL),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.WithHeadSize(true),
+		downloader.SetLog(nopLog{}),
+		downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			return filepath.Join(dir, creator.Id, post.Id, attachment.Name)
+		}),
+	)
+	k := kemono.NewKemono(kemono.WithBaseURL(srv.URL), kemono.SetDownloader(d), kemono.SetLog(nopLog{}),
+		kemono.WithUsersPair("fanbox", "1"), kemono.WithDryRun(true))
+	if err := k.Start(); err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+
+	plan := k.Plan()
+	want := int64(len(fixtures[1].data) + len(fixtures[2].data))
+	if s := plan.Summary; s.Posts != 3 || s.Download != 2 || s.Exists != 1 || s.Bytes != want || s.UnknownSize != 0 {
+		t.Errorf("unexpected plan summary: %+v", s)
+	}
+	for _, f := range fixtures[1:] {
+		if _, err := os.Stat(filepath.Join(dir, f.local)); !os.IsNotExist(err) {
+			t.Errorf("%s should not be downloaded in a dry run", f.local)
+		}
+	}
+	var table bytes.Buffer
+	if err := plan.WriteTable(&table); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(table.String(), filepath.Join(dir, fixtures[1].local)) {
+		t.Errorf("table should list the save paths:\n%s", table.String())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+	fixtures := addCreator(srv, "1", 120, 3)
+
+	pages := func() int {
+		n := 0
+		for _, r := range srv.Requests() {
+			if r == "/api/v1/fanbox/user/1" {
+				n++
+			}
+		}
+		return n
+	}
+	type check struct {
+		summary kemono.Summary
+		pages   int
+	}
+	var checks []check
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k := newKemono(srv, dir, kemono.WithUsersPair("fanbox", "1"))
+	err := k.WatchContext(ctx, kemono.WatchInterval(time.Millisecond), kemono.WatchJitter(0), kemono.OnCheck(func(r *kemono.Report) {
+		checks = append(checks, check{summary: r.Summary, pages: pages()})
+		switch len(checks) {
+		case 1:
+			// a new post, and the creator list shows the update
+			data := []byte("new post")
+			file := srv.AddFile("new.png", data)
+			srv.PublishPost(kemono.PostRaw{Id: "121", Service: "fanbox", User: "1", Title: "post 121",
+				Published: "2023-06-01T00:00:00", Attachments: []kemono.File{file}})
+			fixtures = append(fixtures, fixture{local: filepath.Join("1", "121", "new.png"), data: data, file: file})
+			srv.UpdateCreator(kemono.Creator{Id: "1", Name: "creator 1", Service: "fanbox", Updated: kemono.Timestamp{Time: time.Now().Add(time.Hour)}})
+		case 3:
+			cancel()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("watch failed: %s", err)
+	}
+	checkFiles(t, dir, fixtures)
+
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %d", len(checks))
+	}
+	if c := checks[0]; c.summary.Posts != 120 || c.summary.Downloaded != 3 || c.pages != 4 {
+		t.Errorf("unexpected first check: %+v", c)
+	}
+	// only the first page is fetched, it holds known posts
+	if c := checks[1]; c.summary.Posts != 1 || c.summary.Downloaded != 1 || c.pages-checks[0].pages != 1 {
+		t.Errorf("unexpected second check: %+v", c)
+	}
+	// the creator did not change
+	if c := checks[2]; c.summary.Posts != 0 || c.pages != checks[1].pages {
+		t.Errorf("unexpected third check: %+v", c)
+	}
+}
@@ -0,0 +1,364 @@
+// Package kemonotest provides a fake kemono server for tests.
+//
+// It serves /api/v1/creators, /api/v1/{service}/user/{id}?o=N,
+// /api/v1/{service}/user/{id}/post/{post}, the discord channel lookup and
+// channel pages, and the files under /data/... (and the same paths without
+// /data) from fixtures, and can inject failures into any path. Files are
+// served with an ETag and honour Range and If-Range, so downloads can resume.
+package kemonotest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+// fileType the content type of the files
+const fileType = "application/octet-stream"
+
+// PageSize posts per page of the post list
+const PageSize = 50
+
+// DiscordPageSize messages per page of a discord channel
+const DiscordPageSize = 150
+
+// Fault a failure injected into the responses of a path
+type Fault struct {
+	// Times how many requests the fault applies to, 0 for every request
+	Times int
+	// Status answer with this status instead of the content, e.g. 429 or 503
+	Status int
+	// RetryAfter the Retry-After header sent with Status
+	RetryAfter string
+	// Truncate send only the first Truncate bytes of the body, with the full Content-Length
+	Truncate int
+	// Gzip compress the body with Content-Encoding: gzip
+	Gzip bool
+	// NoContentLength send the body chunked, without Content-Length
+	NoContentLength bool
+	// Stall send only the first Stall bytes, with the full Content-Length, then wait until the request is cancelled
+	Stall int
+}
+
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	creators []kemono.Creator
+	// map[<service>:<id>][]PostRaw
+	posts map[string][]kemono.PostRaw
+	// map[<server id>][]DiscordChannel
+	channels map[string][]kemono.DiscordChannel
+	// map[<channel id>][]DiscordMessage
+	messages map[string][]kemono.DiscordMessage
+	// map[<path without /data>]content
+	files    map[string][]byte
+	faults   map[string][]*Fault
+	requests []string
+	// map[<path>][]<Range header of every request>
+	ranges map[string][]string
+}
+
+// NewServer start a fake kemono server, it should be closed after use
+func NewServer() *Server {
+	s := &Server{
+		posts:    make(map[string][]kemono.PostRaw),
+		channels: make(map[string][]kemono.DiscordChannel),
+		messages: make(map[string][]kemono.DiscordMessage),
+		files:    make(map[string][]byte),
+		faults:   make(map[string][]*Fault),
+		ranges:   make(map[string][]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddCreator add creators to the creator list
+func (s *Server) AddCreator(creators ...kemono.Creator) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.creators = append(s.creators, creators...)
+}
+
+// AddPost add posts to the post list of their creator, newest first
+func (s *Server) AddPost(posts ...kemono.PostRaw) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range posts {
+		key := fmt.Sprintf("%s:%s", p.Service, p.User)
+		s.posts[key] = append(s.posts[key], p)
+	}
+}
+
+// PublishPost add posts before the post list of their creator, as newer ones
+func (s *Server) PublishPost(posts ...kemono.PostRaw) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range posts {
+		key := fmt.Sprintf("%s:%s", p.Service, p.User)
+		s.posts[key] = append([]kemono.PostRaw{p}, s.posts[key]...)
+	}
+}
+
+// AddDiscordChannel add a channel to a discord server, with its messages newest first
+func (s *Server) AddDiscordChannel(server string, channel kemono.DiscordChannel, messages ...kemono.DiscordMessage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.channels[server] = append(s.channels[server], channel)
+	s.messages[channel.Id] = append(s.messages[channel.Id], messages...)
+}
+
+// UpdateCreator replace the creator of the same service and id in the creator list
+func (s *Server) UpdateCreator(creator kemono.Creator) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, c := range s.creators {
+		if c.Service == creator.Service && c.Id == creator.Id {
+			s.creators[i] = creator
+		}
+	}
+}
+
+// AddFile serve data as a kemono file, the path is built from its sha256
+// like kemono does: /<hash[0:2]>/<hash[2:4]>/<hash><ext>
+func (s *Server) AddFile(name string, data []byte) kemono.File {
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	path := fmt.Sprintf("/%s/%s/%s%s", hash[0:2], hash[2:4], hash, filepath.Ext(name))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[path] = data
+	return kemono.File{Name: name, Path: path}
+}
+
+// Fail inject a fault into the responses of path (without query), faults of a path apply in order
+func (s *Server) Fail(path string, fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f := fault
+	s.faults[path] = append(s.faults[path], &f)
+}
+
+// Requests return the request uris served so far
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Ranges return the Range header of every request of path served so far, empty for a request without one
+func (s *Server) Ranges(path string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.ranges[path]...)
+}
+
+// Transport return a RoundTripper sending every request to the server, whatever the host is
+func (s *Server) Transport() http.RoundTripper {
+	u, _ := url.Parse(s.URL)
+	return &rewriteTransport{target: u, base: http.DefaultTransport}
+}
+
+type rewriteTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+	return t.base.RoundTrip(r)
+}
+
+// fault record the request and take the next fault of its path
+func (s *Server) fault(r *http.Request) *Fault {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := r.URL.Path
+	s.requests = append(s.requests, path)
+	s.ranges[path] = append(s.ranges[path], r.Header.Get("Range"))
+	for _, f := range s.faults[path] {
+		if f.Times == 0 {
+			return f
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				// used up
+				f.Times = -1
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f := s.fault(r)
+	if f == nil {
+		f = &Fault{}
+	}
+	if f.Status != 0 {
+		if f.RetryAfter != "" {
+			w.Header().Set("Retry-After", f.RetryAfter)
+		}
+		http.Error(w, http.StatusText(f.Status), f.Status)
+		return
+	}
+
+	body, contentType, status := s.route(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if contentType == fileType {
+		// the hash in the path identifies the content
+		etag := fmt.Sprintf("%q", strings.TrimSuffix(filepath.Base(r.URL.Path), filepath.Ext(r.URL.Path)))
+		w.Header().Set("ETag", etag)
+		if start, ok := rangeStart(r, etag, len(body)); ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+			body, status = body[start:], http.StatusPartialContent
+		}
+	}
+	if f.Stall > 0 && f.Stall < len(body) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		_, _ = w.Write(body[:f.Stall])
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+		<-r.Context().Done()
+		return
+	}
+	write(w, body, contentType, status, f)
+}
+
+// rangeStart return the start of a "bytes=<start>-" Range of the request, ok is false if there is none, it is out
+// of the body, or If-Range does not match the etag: the whole body is sent then
+func rangeStart(r *http.Request, etag string, size int) (start int, ok bool) {
+	header := r.Header.Get("Range")
+	if !strings.HasPrefix(header, "bytes=") || !strings.HasSuffix(header, "-") {
+		return 0, false
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return 0, false
+	}
+	start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, "bytes="), "-"))
+	if err != nil || start < 0 || start >= size {
+		return 0, false
+	}
+	return start, true
+}
+
+// route find the content of the request
+func (s *Server) route(r *http.Request) (body []byte, contentType string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := r.URL.Path
+	switch {
+	case path == "/api/v1/creators":
+		return s.encode(s.creators)
+	case strings.HasPrefix(path, "/api/v1/discord/channel/lookup/"):
+		channels, ok := s.channels[strings.TrimPrefix(path, "/api/v1/discord/channel/lookup/")]
+		if !ok {
+			return nil, "", http.StatusNotFound
+		}
+		return s.encode(channels)
+	case strings.HasPrefix(path, "/api/v1/discord/channel/"):
+		messages, ok := s.messages[strings.TrimPrefix(path, "/api/v1/discord/channel/")]
+		if !ok {
+			return nil, "", http.StatusNotFound
+		}
+		o, _ := strconv.Atoi(r.URL.Query().Get("o"))
+		page := []kemono.DiscordMessage{}
+		if o < len(messages) {
+			end := o + DiscordPageSize
+			if end > len(messages) {
+				end = len(messages)
+			}
+			page = messages[o:end]
+		}
+		return s.encode(page)
+	case strings.HasPrefix(path, "/api/v1/"):
+		// /api/v1/{service}/user/{id} or /api/v1/{service}/user/{id}/post/{post}
+		parts := strings.Split(strings.TrimPrefix(path, "/api/v1/"), "/")
+		if (len(parts) != 3 && (len(parts) != 5 || parts[3] != "post")) || parts[1] != "user" {
+			return nil, "", http.StatusNotFound
+		}
+		posts, ok := s.posts[fmt.Sprintf("%s:%s", parts[0], parts[2])]
+		if !ok {
+			return nil, "", http.StatusNotFound
+		}
+		if len(parts) == 5 {
+			for _, p := range posts {
+				if p.Id == parts[4] {
+					return s.encode(map[string]interface{}{"post": p, "attachments": p.Attachments})
+				}
+			}
+			return nil, "", http.StatusNotFound
+		}
+		o, _ := strconv.Atoi(r.URL.Query().Get("o"))
+		page := []kemono.PostRaw{}
+		if o < len(posts) {
+			end := o + PageSize
+			if end > len(posts) {
+				end = len(posts)
+			}
+			page = posts[o:end]
+		}
+		return s.encode(page)
+	default:
+		data, ok := s.files[strings.TrimPrefix(path, "/data")]
+		if !ok {
+			return nil, "", http.StatusNotFound
+		}
+		return data, fileType, http.StatusOK
+	}
+}
+
+func (s *Server) encode(v interface{}) ([]byte, string, int) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError
+	}
+	return data, "application/json", http.StatusOK
+}
+
+func write(w http.ResponseWriter, body []byte, contentType string, status int, f *Fault) {
+	w.Header().Set("Content-Type", contentType)
+	if f.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write(body)
+		_ = gz.Close()
+		body = buf.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if !f.NoContentLength {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if f.Truncate > 0 && f.Truncate < len(body) {
+		body = body[:f.Truncate]
+	}
+	w.WriteHeader(status)
+	if f.NoContentLength {
+		// flush the header before the body, so the body is sent chunked
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+	}
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,129 @@
+package kemono
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// the file hosts of external links
+const (
+	HostMega        = "mega"
+	HostGoogleDrive = "google-drive"
+	HostDropbox     = "dropbox"
+	HostPixeldrain  = "pixeldrain"
+	HostOther       = "other"
+)
+
+// where an external link was found
+const (
+	LinkContent = "content"
+	LinkEmbed   = "embed"
+)
+
+// textURL an url in the text of the content
+var textURL = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExternalLink a link of a post to another site
+type ExternalLink struct {
+	URL string `json:"url"`
+	// Host the file host of the link: mega, google-drive, dropbox, pixeldrain or other
+	Host string `json:"host"`
+	// Source where the link was found: content or embed
+	Source string `json:"source"`
+}
+
+// ExternalFetcher downloads the files behind the external links of a host
+type ExternalFetcher interface {
+	// Match report whether the fetcher downloads the link
+	Match(link ExternalLink) bool
+	// Fetch download the files of the link into dir, the directory of the post
+	Fetch(ctx context.Context, link ExternalLink, dir string) error
+}
+
+// ExternalDownloader downloads the external links of a post with its ExternalFetchers.
+// A Downloader implementing it is called after the files of every post
+type ExternalDownloader interface {
+	DownloadExternal(ctx context.Context, creator Creator, post Post) error
+}
+
+// ExternalLinks return the links of the post to other sites, from the links, images and text urls of its content
+// and from its embed, in the order they appear and without duplicates. Links to kemono and coomer are left out
+func ExternalLinks(post Post) []ExternalLink {
+	var links []ExternalLink
+	seen := make(map[string]bool)
+	add := func(raw, source string) {
+		raw = strings.TrimRight(strings.TrimSpace(raw), ".,;:!?)]}")
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || siteHost(u.Hostname()) {
+			return
+		}
+		if seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		links = append(links, ExternalLink{URL: u.String(), Host: LinkHost(u), Source: source})
+	}
+
+	if post.Content != "" {
+		nodes, err := html.ParseFragment(strings.NewReader(post.Content), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+		if err == nil {
+			var visit func(n *html.Node)
+			visit = func(n *html.Node) {
+				switch n.Type {
+				case html.ElementNode:
+					for _, a := range n.Attr {
+						if a.Key == "href" || a.Key == "src" {
+							add(a.Val, LinkContent)
+						}
+					}
+				case html.TextNode:
+					for _, raw := range textURL.FindAllString(n.Data, -1) {
+						add(raw, LinkContent)
+					}
+				}
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					visit(c)
+				}
+			}
+			for _, n := range nodes {
+				visit(n)
+			}
+		}
+	}
+	if !post.Embed.IsZero() {
+		add(post.Embed.URL, LinkEmbed)
+	}
+	return links
+}
+
+// LinkHost classify the url by its file host: mega, google-drive, dropbox, pixeldrain or other
+func LinkHost(u *url.URL) string {
+	hostname := strings.ToLower(u.Hostname())
+	switch {
+	case hostIs(hostname, "mega.nz", "mega.co.nz", "mega.io"):
+		return HostMega
+	case hostIs(hostname, "drive.google.com", "docs.google.com"):
+		return HostGoogleDrive
+	case hostIs(hostname, "dropbox.com", "dropboxusercontent.com", "db.tt"):
+		return HostDropbox
+	case hostIs(hostname, "pixeldrain.com"):
+		return HostPixeldrain
+	default:
+		return HostOther
+	}
+}
+
+// hostIs report whether hostname is one of the domains or a subdomain of them
+func hostIs(hostname string, domains ...string) bool {
+	for _, domain := range domains {
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package kemono
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/elvis972602/kemono-scraper/utils"
+)
+
+// postQueueSize posts fetched ahead of the download stage, so fetching the next
+// creator overlaps with downloading the current one
+const postQueueSize = 1000
+
+// postJob a post waiting to be downloaded
+type postJob struct {
+	creator Creator
+	post    Post
+	// partial files of the post were left out by the attachment filters
+	partial bool
+}
+
+// fetchPosts fetch the posts of a creator for the fetch stage
+type fetchPosts func(ctx context.Context, creator Creator) ([]Post, error)
+
+// fetchAll fetch the posts of the creator, until the first post past its cutoffs. The posts selected by
+// WithUserPosts are fetched one by one, a post failing to fetch is reported and left out, and the messages of a
+// discord server channel by channel
+func (k *Kemono) fetchAll(ctx context.Context, creator Creator) ([]Post, error) {
+	if ids, ok := k.onlyPosts(creator); ok {
+		posts := make([]Post, 0, len(ids))
+		for _, id := range ids {
+			post, err := k.FetchPostContext(ctx, creator.Service, creator.Id, id)
+			if err != nil {
+				if cerr := Cancelled(ctx); cerr != nil {
+					return nil, cerr
+				}
+				// e.g. a deleted post, the others are still downloaded
+				k.reportError(err)
+				continue
+			}
+			posts = append(posts, post)
+		}
+		return posts, nil
+	}
+	if creator.Service == "discord" {
+		return k.fetchDiscord(ctx, creator)
+	}
+	return k.FetchPostsUntilContext(ctx, creator.Service, creator.Id, k.pastCutoff)
+}
+
+// fetchStage fetch and filter the posts of every creator into jobs, closes jobs when finished.
+// The first fetch error stops the stage and is returned
+func (k *Kemono) fetchStage(ctx context.Context, creators []Creator, jobs chan<- postJob, fetch fetchPosts) error {
+	defer close(jobs)
+	for _, creator := range creators {
+		if err := Cancelled(ctx); err != nil {
+			return err
+		}
+		// fetch posts
+		posts, err := fetch(ctx, creator)
+		if err != nil {
+			k.report.addError(err)
+			return err
+		}
+		for _, job := range k.preparePosts(creator, posts) {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return Cancelled(ctx)
+			}
+		}
+	}
+	return nil
+}
+
+// preparePosts filter posts, add the banner and the content files and filter attachments
+func (k *Kemono) preparePosts(creator Creator, posts []Post) []postJob {
+	// filter posts
+	posts = k.FilterPosts(posts)
+
+	// filter attachments
+	jobs := make([]postJob, 0, len(posts))
+	for _, post := range posts {
+		// download banner if banner is true or file is not image
+		if (k.Banner || !isImage(filepath.Ext(post.File.Name))) && post.File.Path != "" {
+			res := make([]File, len(post.Attachments)+1)
+			copy(res[1:], post.Attachments)
+			res[0] = post.File
+			post.Attachments = res
+		}
+		if k.contentFiles {
+			post.Attachments = addContentFiles(post.Attachments, k.ContentFiles(post))
+		}
+		all := len(post.Attachments)
+		post.Attachments = k.FilterAttachments(fmt.Sprintf("%s:%s", post.Service, post.User), post.Attachments)
+		jobs = append(jobs, postJob{creator: creator, post: post, partial: len(post.Attachments) < all})
+	}
+	return jobs
+}
+
+// downloadExternal download the external links of the post if the downloader is an ExternalDownloader,
+// a failure is added to the report of the post
+func (k *Kemono) downloadExternal(ctx context.Context, creator Creator, post Post, pr *PostReport) {
+	e, ok := k.Downloader.(ExternalDownloader)
+	if !ok {
+		return
+	}
+	if err := e.DownloadExternal(ctx, creator, post); err != nil && ctx.Err() == nil {
+		k.log.Printf("download external links error: %s", err)
+		if pr.Error == "" {
+			pr.Error = err.Error()
+		}
+	}
+}
+
+// export write the post with the downloader if it is an Exporter, a failure is added to the report of the post
+func (k *Kemono) export(creator Creator, post Post, pr *PostReport) {
+	e, ok := k.Downloader.(Exporter)
+	if !ok {
+		return
+	}
+	if err := e.Export(creator, post, pr.Files); err != nil {
+		k.log.Printf("export post error: %s", err)
+		if pr.Error == "" {
+			pr.Error = err.Error()
+		}
+	}
+}
+
+// downloadStage download the posts of jobs with k.postConcurrency workers, until jobs is closed.
+// Files of all posts share the worker pool of the downloader
+func (k *Kemono) downloadStage(ctx context.Context, jobs <-chan postJob) {
+	var wg sync.WaitGroup
+	for i := 0; i < k.postConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					// drain the queue, so the fetch stage is not blocked
+					continue
+				}
+				k.downloadPost(ctx, job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// downloadPost write the content and download the files of the post, the outcome is added to the report
+func (k *Kemono) downloadPost(ctx context.Context, job postJob) {
+	creator, post := job.creator, job.post
+	if k.dryRun {
+		k.planPost(ctx, creator, post)
+		return
+	}
+	if k.state != nil {
+		done, err := k.state.PostDone(post)
+		if err != nil {
+			k.log.Printf("check post state error: %s", err)
+		} else if done {
+			k.log.Printf("post %s already downloaded, skip", utils.ValidDirectoryName(post.Title))
+			k.report.addPost(PostReport{Service: post.Service, User: post.User, Id: post.Id, Title: post.Title, Skipped: true})
+			return
+		}
+	}
+	k.log.Printf("download post: %s", utils.ValidDirectoryName(post.Title))
+	pr := PostReport{
+		Service: post.Service,
+		User:    post.User,
+		Id:      post.Id,
+		Title:   post.Title,
+	}
+	if post.Content != "" {
+		err := k.Downloader.WriteContent(creator, post, post.Content)
+		if err != nil {
+			k.log.Printf("write content error: %s", err)
+			pr.Error = err.Error()
+		}
+	}
+	k.downloadExternal(ctx, creator, post, &pr)
+	failed := false
+	if len(post.Attachments) > 0 {
+		failed = k.downloadFiles(ctx, creator, post, AddIndexToAttachments(post.Attachments), &pr)
+	}
+	if ctx.Err() == nil {
+		k.export(creator, post, &pr)
+	}
+	k.report.addPost(pr)
+	// a post missing files, failed or left out by the filters or the size limit, or whose content, external links
+	// or export failed, is not done: a later run tries again
+	if k.state != nil && !failed && !job.partial && !sizeSkipped(pr) && pr.Error == "" && ctx.Err() == nil {
+		if err := k.state.MarkPost(post); err != nil {
+			k.log.Printf("record post state error: %s", err)
+		}
+	}
+}
+
+// sizeSkipped report whether a file of the post was skipped by the size limit
+func sizeSkipped(pr PostReport) bool {
+	for _, f := range pr.Files {
+		if f.Status == FileSkippedSize {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package kemono
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/elvis972602/kemono-scraper/utils"
+)
+
+// FilePlanned the file would be downloaded, used in dry-run mode
+const FilePlanned FileStatus = "planned"
+
+// Planner is implemented by a Downloader that can resolve files without downloading them, used in dry-run mode
+type Planner interface {
+	// PlanContext resolve the save path of the file, and whether it would be downloaded
+	PlanContext(ctx context.Context, creator Creator, post Post, file FileWithIndex) PlannedFile
+}
+
+// PlannedFile a file of the plan
+type PlannedFile struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	SavePath string `json:"save_path"`
+	// Status FilePlanned, FileExists, FileLinked or FileSkippedSize
+	Status FileStatus `json:"status"`
+	// Size the size from a HEAD request, -1 if unknown
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// PlannedPost a post of the plan
+type PlannedPost struct {
+	Service string        `json:"service"`
+	User    string        `json:"user"`
+	Id      string        `json:"id"`
+	Title   string        `json:"title"`
+	Files   []PlannedFile `json:"files"`
+}
+
+// PlanSummary counts of a plan
+type PlanSummary struct {
+	Posts       int `json:"posts"`
+	Files       int `json:"files"`
+	Download    int `json:"download"`
+	Exists      int `json:"exists"`
+	Linked      int `json:"linked"`
+	SkippedSize int `json:"skipped_size"`
+	// Bytes the known size of the files to download, UnknownSize the files without a size
+	Bytes       int64 `json:"bytes"`
+	UnknownSize int   `json:"unknown_size"`
+}
+
+// Plan what a run would download, built in dry-run mode
+type Plan struct {
+	Site    string        `json:"site"`
+	Summary PlanSummary   `json:"summary"`
+	Posts   []PlannedPost `json:"posts"`
+
+	lock sync.Mutex
+}
+
+func (p *Plan) addPost(post PlannedPost) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Posts = append(p.Posts, post)
+	p.Summary.Posts++
+	for _, f := range post.Files {
+		p.Summary.Files++
+		switch f.Status {
+		case FilePlanned:
+			p.Summary.Download++
+			if f.Size >= 0 {
+				p.Summary.Bytes += f.Size
+			} else {
+				p.Summary.UnknownSize++
+			}
+		case FileExists:
+			p.Summary.Exists++
+		case FileLinked:
+			p.Summary.Linked++
+		case FileSkippedSize:
+			p.Summary.SkippedSize++
+		}
+	}
+}
+
+// JSON encode the plan
+func (p *Plan) JSON() ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// WriteTable write the plan as a table, one file per line, followed by the summary
+func (p *Plan) WriteTable(w io.Writer) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tSIZE\tCREATOR\tPOST\tSAVE PATH")
+	for _, post := range p.Posts {
+		for _, f := range post.Files {
+			size := "-"
+			if f.Size >= 0 {
+				size = utils.FormatSize(f.Size)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s:%s\t%s\t%s\n", f.Status, size, post.Service, post.User, post.Id, f.SavePath)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	s := p.Summary
+	_, err := fmt.Fprintf(w, "%s: %d posts, %d files: %d to download (%s, %d of unknown size), %d exist, %d linked, %d skipped by size\n",
+		p.Site, s.Posts, s.Files, s.Download, utils.FormatSize(s.Bytes), s.UnknownSize, s.Exists, s.Linked, s.SkippedSize)
+	return err
+}
+
+// planPost add the files of the post to the plan, without downloading anything
+func (k *Kemono) planPost(ctx context.Context, creator Creator, post Post) {
+	pp := PlannedPost{
+		Service: post.Service,
+		User:    post.User,
+		Id:      post.Id,
+		Title:   post.Title,
+	}
+	planner, ok := k.Downloader.(Planner)
+	for _, file := range AddIndexToAttachments(post.Attachments) {
+		if Cancelled(ctx) != nil {
+			return
+		}
+		if ok {
+			pp.Files = append(pp.Files, planner.PlanContext(ctx, creator, post, file))
+			continue
+		}
+		// the save path is only known to the downloader
+		pp.Files = append(pp.Files, PlannedFile{Index: file.Index, Name: file.Name, Path: file.Path, Status: FilePlanned, Size: -1})
+	}
+	k.plan.addPost(pp)
+}
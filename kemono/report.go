@@ -0,0 +1,209 @@
+package kemono
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type FileStatus string
+
+const (
+	// FileDownloaded the file was downloaded
+	FileDownloaded FileStatus = "downloaded"
+	// FileExists the file already exists, skipped
+	FileExists FileStatus = "exists"
+	// FileLinked the file was already in the content addressed store, linked to its save path
+	FileLinked FileStatus = "linked"
+	// FileSkippedSize the file is out of the max/min size, skipped
+	FileSkippedSize FileStatus = "skipped_size"
+	// FileFailed the file failed to download
+	FileFailed FileStatus = "failed"
+)
+
+// FileResult the outcome of downloading a file
+type FileResult struct {
+	Index    int        `json:"index"`
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	SavePath string     `json:"save_path"`
+	Status   FileStatus `json:"status"`
+	// StatusCode the last http status, 0 if no response
+	StatusCode int   `json:"status_code,omitempty"`
+	Bytes      int64 `json:"bytes"`
+	// Err the error if the file failed
+	Err   error  `json:"-"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewFileResult create a FileResult of the file
+func NewFileResult(file FileWithIndex, savePath string, status FileStatus) FileResult {
+	return FileResult{
+		Index:    file.Index,
+		Name:     file.Name,
+		Path:     file.Path,
+		SavePath: savePath,
+		Status:   status,
+	}
+}
+
+// Failed mark the result as failed with err
+func (r FileResult) Failed(err error) FileResult {
+	r.Status = FileFailed
+	r.Err = err
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// PostReport the outcome of a post
+type PostReport struct {
+	Service string       `json:"service"`
+	User    string       `json:"user"`
+	Id      string       `json:"id"`
+	Title   string       `json:"title"`
+	Files   []FileResult `json:"files"`
+	// Skipped the post was completely downloaded by an earlier run, recorded in the state, its files are not listed
+	Skipped bool `json:"skipped,omitempty"`
+	// Error the error of writing content
+	Error string `json:"error,omitempty"`
+}
+
+// Failed return the failed files of the post
+func (p PostReport) Failed() []FileResult {
+	var files []FileResult
+	for _, f := range p.Files {
+		if f.Status == FileFailed {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Summary counts of a run
+type Summary struct {
+	Posts       int   `json:"posts"`
+	Downloaded  int   `json:"downloaded"`
+	Exists      int   `json:"exists"`
+	Linked      int   `json:"linked"`
+	SkippedSize int   `json:"skipped_size"`
+	Failed      int   `json:"failed"`
+	Bytes       int64 `json:"bytes"`
+	// SkippedPosts the posts skipped as already downloaded, counted in Posts too
+	SkippedPosts int `json:"skipped_posts"`
+}
+
+// Report the outcome of a run, with every post and file
+type Report struct {
+	Site    string       `json:"site"`
+	Start   time.Time    `json:"start"`
+	End     time.Time    `json:"end"`
+	Summary Summary      `json:"summary"`
+	Posts   []PostReport `json:"posts"`
+	// Errors errors not related to a single file, e.g. fetching post list
+	Errors []string `json:"errors,omitempty"`
+
+	lock sync.Mutex
+}
+
+// DownloadError is returned by DownloadPostsContext when files of the posts failed to download or their content
+// failed to be written, Posts are the reports of these posts
+type DownloadError struct {
+	Posts []PostReport
+}
+
+func (e *DownloadError) Error() string {
+	files, first := 0, ""
+	for _, p := range e.Posts {
+		if p.Error != "" && first == "" {
+			first = p.Error
+		}
+		for _, f := range p.Failed() {
+			files++
+			if first == "" {
+				first = f.Error
+			}
+		}
+	}
+	return fmt.Sprintf("download posts error: %d posts with %d failed files: %s", len(e.Posts), files, first)
+}
+
+func newReport(site string) *Report {
+	return &Report{Site: site, Start: time.Now()}
+}
+
+func (r *Report) addPost(p PostReport) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Posts = append(r.Posts, p)
+	r.Summary.Posts++
+	if p.Skipped {
+		r.Summary.SkippedPosts++
+	}
+	for _, f := range p.Files {
+		switch f.Status {
+		case FileDownloaded:
+			r.Summary.Downloaded++
+		case FileExists:
+			r.Summary.Exists++
+		case FileLinked:
+			r.Summary.Linked++
+		case FileSkippedSize:
+			r.Summary.SkippedSize++
+		case FileFailed:
+			r.Summary.Failed++
+		}
+		r.Summary.Bytes += f.Bytes
+	}
+}
+
+// postCount the number of posts reported so far
+func (r *Report) postCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.Posts)
+}
+
+// failedSince return the posts reported after the first n with failed files or a content error
+func (r *Report) failedSince(n int) []PostReport {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var failed []PostReport
+	for _, p := range r.Posts[n:] {
+		if p.Error != "" || len(p.Failed()) > 0 {
+			failed = append(failed, p)
+		}
+	}
+	return failed
+}
+
+func (r *Report) addError(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *Report) finish() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.End = time.Now()
+}
+
+// JSON encode the report
+func (r *Report) JSON() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// WriteFile write the report to path as json
+func (r *Report) WriteFile(path string) error {
+	data, err := r.JSON()
+	if err != nil {
+		return fmt.Errorf("encode report error: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
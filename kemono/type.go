@@ -1,12 +1,15 @@
 package kemono
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/elvis972602/kemono-scraper/utils"
 	"github.com/spf13/cast"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -25,6 +28,13 @@ func (t *Timestamp) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.Time.IsZero() {
+		return []byte("0"), nil
+	}
+	return json.Marshal(float64(t.Time.UnixNano()) / 1e9)
+}
+
 type Creator struct {
 	Favorited int       `json:"favorited"`
 	Id        string    `json:"id"`
@@ -68,6 +78,8 @@ func FindCreator(creators []Creator, id, service string) (Creator, bool) {
 type File struct {
 	Name string `json:"name"`
 	Path string `json:"path"`
+	// Server the file server the site suggests for the file, e.g. https://n1.kemono.su, returned for a single post
+	Server string `json:"server,omitempty"`
 }
 
 // GetURL return the url
@@ -92,53 +104,189 @@ func (f File) Index(n int) FileWithIndex {
 type FileWithIndex struct {
 	Index int
 	File
+	// Target the path to save the file, if set, overrides the downloader SavePath
+	Target string
+}
+
+// Embed the link a post embeds, e.g. a video
+type Embed struct {
+	URL         string `json:"url,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// IsZero report whether the post embeds nothing
+func (e Embed) IsZero() bool {
+	return e.URL == ""
+}
+
+// UnmarshalJSON the api returns an empty array or string for a post without embed, any value but an object is empty
+func (e *Embed) UnmarshalJSON(b []byte) error {
+	*e = Embed{}
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '{' {
+		return nil
+	}
+	type embed Embed
+	if err := json.Unmarshal(b, (*embed)(e)); err != nil {
+		return fmt.Errorf("unmarshal embed error: %w", err)
+	}
+	return nil
+}
+
+// Poll a poll of a post
+type Poll struct {
+	Title          string       `json:"title"`
+	Description    string       `json:"description,omitempty"`
+	Choices        []PollChoice `json:"choices"`
+	AllowsMultiple bool         `json:"allows_multiple"`
+	TotalVotes     int          `json:"total_votes"`
+	CreatedAt      string       `json:"created_at,omitempty"`
+	ClosesAt       string       `json:"closes_at,omitempty"`
+}
+
+type PollChoice struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Tags the tags of a post, the api returns a json array or a postgres array literal like {a,"b c"}
+type Tags []string
+
+func (t *Tags) UnmarshalJSON(b []byte) error {
+	var tags []string
+	if err := json.Unmarshal(b, &tags); err == nil {
+		*t = tags
+		return nil
+	}
+	var literal *string
+	if err := json.Unmarshal(b, &literal); err != nil {
+		return fmt.Errorf("unmarshal tags error: %w", err)
+	}
+	*t = nil
+	if literal == nil {
+		return nil
+	}
+	s := strings.TrimSuffix(strings.TrimPrefix(*literal, "{"), "}")
+	if s == "" {
+		return nil
+	}
+	r := csv.NewReader(strings.NewReader(s))
+	r.LazyQuotes = true
+	tags, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("unmarshal tags error: %w", err)
+	}
+	*t = tags
+	return nil
+}
+
+// DateError a date of a post that could not be parsed
+type DateError struct {
+	Post  string
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *DateError) Error() string {
+	return fmt.Sprintf("post %s: parse %s date %q error: %s", e.Post, e.Field, e.Value, e.Err)
+}
+
+func (e *DateError) Unwrap() error {
+	return e.Err
 }
 
 type PostRaw struct {
-	Added       string      `json:"added"`
-	Attachments []File      `json:"attachments"`
-	Content     string      `json:"content"`
-	Edited      string      `json:"edited"`
-	Embed       interface{} `json:"embed"`
-	File        File        `json:"file"`
-	Id          string      `json:"id"`
-	Published   string      `json:"published"`
-	Service     string      `json:"service"`
-	SharedFile  bool        `json:"shared_file"`
-	Title       string      `json:"title"`
-	User        string      `json:"user"`
+	Added       string          `json:"added"`
+	Attachments []File          `json:"attachments"`
+	Content     string          `json:"content"`
+	Substring   string          `json:"substring,omitempty"`
+	Edited      string          `json:"edited"`
+	Embed       Embed           `json:"embed"`
+	File        File            `json:"file"`
+	Id          string          `json:"id"`
+	Published   string          `json:"published"`
+	Service     string          `json:"service"`
+	SharedFile  bool            `json:"shared_file"`
+	Title       string          `json:"title"`
+	User        string          `json:"user"`
+	Tags        Tags            `json:"tags,omitempty"`
+	Poll        *Poll           `json:"poll,omitempty"`
+	Captions    json.RawMessage `json:"captions,omitempty"`
+	// Next and Prev the ids of the next and previous posts of the creator, returned for a single post
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Parse parse the dates of the post. An empty date is the zero time, a date that can not be parsed is a
+// *DateError. A post without a valid published date is dated by its added date, so it sorts and filters
+// by when it appeared. The post is returned with the error, the first one if there are several
+func (p PostRaw) Parse() (Post, error) {
+	var err error
+	parse := func(field, value string) time.Time {
+		if value == "" {
+			return time.Time{}
+		}
+		t, perr := cast.StringToDate(value)
+		if perr != nil && err == nil {
+			err = &DateError{Post: p.Id, Field: field, Value: value, Err: perr}
+		}
+		return t
+	}
+	post := Post{
+		Added:       parse("added", p.Added),
+		Edited:      parse("edited", p.Edited),
+		Published:   parse("published", p.Published),
+		Id:          p.Id,
+		Service:     p.Service,
+		Title:       p.Title,
+		User:        p.User,
+		Content:     p.Content,
+		Substring:   p.Substring,
+		Embed:       p.Embed,
+		SharedFile:  p.SharedFile,
+		File:        p.File,
+		Attachments: p.Attachments,
+		Tags:        p.Tags,
+		Poll:        p.Poll,
+		Captions:    p.Captions,
+		Next:        p.Next,
+		Prev:        p.Prev,
+	}
+	if post.Published.IsZero() {
+		post.Published = post.Added
+	}
+	return post, err
 }
 
+// ParasTime parse the dates of the post, a date that can not be parsed is the zero time, see Parse
 func (p PostRaw) ParasTime() Post {
-	var post Post
-	post.Added, _ = cast.StringToDate(p.Added)
-	post.Edited, _ = cast.StringToDate(p.Edited)
-	post.Published, _ = cast.StringToDate(p.Published)
-	post.Id = p.Id
-	post.Service = p.Service
-	post.Title = p.Title
-	post.User = p.User
-	post.Content = p.Content
-	post.Embed = p.Embed
-	post.SharedFile = p.SharedFile
-	post.File = p.File
-	post.Attachments = p.Attachments
+	post, _ := p.Parse()
 	return post
 }
 
 type Post struct {
-	Added       time.Time   `json:"added"`
-	Attachments []File      `json:"attachments"`
-	Content     string      `json:"content"`
-	Edited      time.Time   `json:"edited"`
-	Embed       interface{} `json:"embed"`
-	File        File        `json:"file"`
-	Id          string      `json:"id"`
-	Published   time.Time   `json:"published"`
-	Service     string      `json:"service"`
-	SharedFile  bool        `json:"shared_file"`
-	Title       string      `json:"title"`
-	User        string      `json:"user"`
+	Added       time.Time       `json:"added"`
+	Attachments []File          `json:"attachments"`
+	Content     string          `json:"content"`
+	Substring   string          `json:"substring,omitempty"`
+	Edited      time.Time       `json:"edited"`
+	Embed       Embed           `json:"embed"`
+	File        File            `json:"file"`
+	Id          string          `json:"id"`
+	Published   time.Time       `json:"published"`
+	Service     string          `json:"service"`
+	SharedFile  bool            `json:"shared_file"`
+	Title       string          `json:"title"`
+	User        string          `json:"user"`
+	Tags        Tags            `json:"tags,omitempty"`
+	Poll        *Poll           `json:"poll,omitempty"`
+	Captions    json.RawMessage `json:"captions,omitempty"`
+	Next        string          `json:"next,omitempty"`
+	Prev        string          `json:"prev,omitempty"`
+
+	// cutoff set, the post probes a filter for its cutoff, see filterCutoff
+	cutoff *PostCutoff
 }
 
 // User a creator according to the service and id
@@ -0,0 +1,291 @@
+package kemono
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultWatchInterval time between two checks of a watch
+	defaultWatchInterval = 30 * time.Minute
+	// defaultWatchJitter the interval varies by up to 10% either way
+	defaultWatchJitter = 0.1
+)
+
+// CreatorState records the last update of the creators synced by a watch, so unchanged creators are skipped
+// across runs. A State implementing it is used by WatchContext
+type CreatorState interface {
+	// CreatorSynced return the update time of the creator when it was last synced, ok is false if it never was
+	CreatorSynced(creator Creator) (updated time.Time, ok bool, err error)
+	// MarkCreator record that the creator was synced at its update time
+	MarkCreator(creator Creator) error
+}
+
+// WatchOption configures a watch
+type WatchOption func(*watcher)
+
+// WatchInterval set the time between two checks, default 30 minutes
+func WatchInterval(interval time.Duration) WatchOption {
+	return func(w *watcher) {
+		w.interval = interval
+	}
+}
+
+// WatchJitter vary the interval randomly by up to jitter times it either way, e.g. 0.1 for 10%, default 0.1
+func WatchJitter(jitter float64) WatchOption {
+	return func(w *watcher) {
+		w.jitter = jitter
+	}
+}
+
+// WatchUsers set a function returning more creators to watch, called before every check, e.g. to follow the
+// favorites of an account. They are added to the creators selected with WithUsers. Without it the creators
+// selected with WithUsers are watched, or all creators if there are none
+func WatchUsers(users func(ctx context.Context) ([]Creator, error)) WatchOption {
+	return func(w *watcher) {
+		w.users = users
+	}
+}
+
+// OnCheck call f with the report of every check when it is done
+func OnCheck(f func(report *Report)) WatchOption {
+	return func(w *watcher) {
+		w.onCheck = f
+	}
+}
+
+// watcher the state of a watch between checks
+type watcher struct {
+	interval time.Duration
+	jitter   float64
+	users    func(ctx context.Context) ([]Creator, error)
+	onCheck  func(report *Report)
+
+	// update time of the creators when they were last synced, map[<service>:<id>]time.Time
+	synced map[string]time.Time
+	// posts seen in earlier checks and their edit time, map[<service>:<user>:<id>]time.Time
+	seen map[string]time.Time
+}
+
+// Watch poll the creators for new and recently edited posts, see WatchContext
+func (k *Kemono) Watch(options ...WatchOption) error {
+	return k.WatchContext(context.Background(), options...)
+}
+
+// WatchContext poll the creators for new and recently edited posts until ctx is done, then return nil.
+// Every check fetches the creator list, skips the creators whose update time did not change since they were
+// last synced, and pages the post list of the others only until it reaches posts already known, from the state
+// or from earlier checks. Only new posts and the edited posts among the pages read are downloaded, edits to
+// older posts are not seen. A check stopped by ctx does not mark its creators synced, they are checked again
+// next time
+func (k *Kemono) WatchContext(ctx context.Context, options ...WatchOption) error {
+	w := &watcher{
+		interval: defaultWatchInterval,
+		jitter:   defaultWatchJitter,
+		synced:   make(map[string]time.Time),
+		seen:     make(map[string]time.Time),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	for {
+		err := k.check(ctx, w)
+		if w.onCheck != nil {
+			w.onCheck(k.report)
+		}
+		if Cancelled(ctx) != nil {
+			return nil
+		}
+		if err != nil {
+			k.log.Printf("check creators error: %s", err)
+		}
+		delay := w.next()
+		k.log.Printf("next check at %s", time.Now().Add(delay).Format("2006-01-02 15:04:05"))
+		if sleepContext(ctx, delay) != nil {
+			return nil
+		}
+	}
+}
+
+// next return the interval with jitter applied
+func (w *watcher) next() time.Duration {
+	if w.jitter <= 0 {
+		return w.interval
+	}
+	return time.Duration(float64(w.interval) * (1 + w.jitter*(2*rand.Float64()-1)))
+}
+
+// check download the new and recently edited posts of the creators updated since they were last synced
+func (k *Kemono) check(ctx context.Context, w *watcher) error {
+	k.report = newReport(k.Site)
+	defer k.report.finish()
+
+	creators, err := k.FetchCreatorsContext(ctx)
+	if err != nil {
+		k.report.addError(err)
+		return err
+	}
+	users := k.users
+	if w.users != nil {
+		more, err := w.users(ctx)
+		if err != nil {
+			k.report.addError(err)
+			return err
+		}
+		for _, c := range more {
+			// the watched creators get all their posts, even if some were selected by WithUserPosts
+			k.allPosts[c.PairString()] = true
+		}
+		users = uniqueCreators(append(append([]Creator(nil), k.users...), more...))
+		if len(users) == 0 {
+			// an empty list of watched creators does not mean all of them
+			k.log.Print("no creators to watch")
+			return nil
+		}
+	}
+	var updated []Creator
+	for _, c := range k.selectCreators(creators, users) {
+		if last, ok := k.lastSynced(w, c); ok && !c.Updated.Time.After(last) {
+			continue
+		}
+		updated = append(updated, c)
+	}
+	k.log.Printf("%d creators updated", len(updated))
+	if len(updated) == 0 {
+		return nil
+	}
+
+	// posts fetched of every creator whose post list was read completely
+	fetched := make(map[string][]Post)
+	fetch := func(ctx context.Context, creator Creator) ([]Post, error) {
+		posts, err := k.fetchNew(ctx, w, creator)
+		if err == nil {
+			fetched[creator.PairString()] = posts
+		}
+		return posts, err
+	}
+	jobs := make(chan postJob, postQueueSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		fetchErr <- k.fetchStage(ctx, updated, jobs, fetch)
+	}()
+	k.downloadStage(ctx, jobs)
+	err = <-fetchErr
+	if cerr := Cancelled(ctx); cerr != nil {
+		return cerr
+	}
+
+	// a creator is synced when all of its posts were downloaded, otherwise the next check tries again
+	failed := make(map[string]bool)
+	for _, p := range k.report.Posts {
+		if p.Error != "" || len(p.Failed()) > 0 {
+			failed[p.Service+":"+p.User] = true
+		}
+	}
+	for _, c := range updated {
+		posts, ok := fetched[c.PairString()]
+		if !ok || failed[c.PairString()] {
+			continue
+		}
+		for _, post := range posts {
+			w.seen[postKey(post)] = post.Edited
+		}
+		w.synced[c.PairString()] = c.Updated.Time
+		if cs, ok := k.state.(CreatorState); ok {
+			if err := cs.MarkCreator(c); err != nil {
+				k.log.Printf("record creator state error: %s", err)
+			}
+		}
+	}
+	return err
+}
+
+// lastSynced return the update time of the creator when it was last synced, from the watch or the state
+func (k *Kemono) lastSynced(w *watcher, c Creator) (time.Time, bool) {
+	if t, ok := w.synced[c.PairString()]; ok {
+		return t, true
+	}
+	cs, ok := k.state.(CreatorState)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, ok, err := cs.CreatorSynced(c)
+	if err != nil {
+		k.log.Printf("check creator state error: %s", err)
+		return time.Time{}, false
+	}
+	return t, ok
+}
+
+// fetchNew fetch the posts of the creator not known yet or edited since, paging stops at the first page
+// holding a known post, or at the first post past a cutoff. The posts on the pages after it are not compared,
+// so only recent edits are picked up
+func (k *Kemono) fetchNew(ctx context.Context, w *watcher, creator Creator) ([]Post, error) {
+	var posts []Post
+	if _, ok := k.onlyPosts(creator); ok || creator.Service == "discord" {
+		// the selected posts and the discord channels are fetched whole
+		all, err := k.fetchAll(ctx, creator)
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range all {
+			if !k.known(w, post) {
+				posts = append(posts, post)
+			}
+		}
+		return posts, nil
+	}
+	err := k.FetchPostPagesContext(ctx, creator.Service, creator.Id, func(page []Post) bool {
+		more := true
+		for _, post := range page {
+			if k.pastCutoff(post) {
+				return false
+			}
+			if k.known(w, post) {
+				more = false
+				continue
+			}
+			posts = append(posts, post)
+		}
+		return more
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// known report whether the post was downloaded before and not edited since
+func (k *Kemono) known(w *watcher, post Post) bool {
+	if edited, ok := w.seen[postKey(post)]; ok && edited.Equal(post.Edited) {
+		return true
+	}
+	if k.state == nil {
+		return false
+	}
+	done, err := k.state.PostDone(post)
+	if err != nil {
+		k.log.Printf("check post state error: %s", err)
+		return false
+	}
+	return done
+}
+
+// uniqueCreators remove the duplicates of creators, keeping the first one
+func uniqueCreators(creators []Creator) []Creator {
+	seen := make(map[string]bool)
+	unique := creators[:0]
+	for _, c := range creators {
+		if seen[c.PairString()] {
+			continue
+		}
+		seen[c.PairString()] = true
+		unique = append(unique, c)
+	}
+	return unique
+}
+
+func postKey(post Post) string {
+	return post.Service + ":" + post.User + ":" + post.Id
+}
@@ -4,11 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"github.com/mattn/go-colorable"
-	"gopkg.in/yaml.v3"
-	"io/ioutil"
 	"log"
-	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -45,6 +43,8 @@ var (
 	extensionOnly string
 	// extension exclude
 	extensionExclude string
+	// only the posts newer than this post id
+	sincePost string
 
 	// download options
 	// output directory
@@ -61,6 +61,14 @@ var (
 	archiveTemplate string
 	// content
 	content bool
+	// export formats of the posts
+	exportFormats string
+	// download the files referenced in the post content
+	contentFiles bool
+	// point the links of content.html to the downloaded files
+	rewriteContent bool
+	// file hosts whose external links are downloaded
+	externalHosts string
 	// async
 	async bool
 	// max size
@@ -79,10 +87,40 @@ var (
 	retryInterval float64
 	// max download goroutine
 	maxDownloadParallel int
-	// request per second
-	rateLimit int
+	// posts downloaded at the same time
+	postParallel int
+	// file requests per second of each host
+	rateLimit float64
+	// api requests per second
+	apiRateLimit float64
+	// requests sent at once before the rate limit applies
+	rateBurst int
 	// proxy url
 	proxy string
+	// origin of kemono, e.g. a mirror
+	kemonoURL string
+	// origin of coomer, e.g. a mirror
+	coomerURL string
+	// download state database
+	statePath string
+	// build a plan instead of downloading
+	dryRun bool
+	// plan output: table or json
+	planFormat string
+	// get the size of planned files with HEAD requests
+	headSize bool
+	// content addressed store directory
+	storePath string
+	// how save paths refer to the store: hardlink, symlink or reflink
+	storeLink string
+	// run report file
+	reportPath string
+	// report of a previous run, re-download its failed files only
+	retryFailed string
+	// time between two checks of the watch command
+	watchInterval time.Duration
+	// the watch interval varies randomly by up to this fraction either way
+	watchJitter float64
 
 	// download favorite creator
 	favoriteCreator bool
@@ -94,14 +132,16 @@ var (
 	cookieFile string
 )
 
-var (
-	config      map[string]interface{}
-	passedFlags = make(map[string]bool)
-)
+// passedFlags the flags set on the command line, the config and the environment do not change them
+var passedFlags = make(map[string]bool)
 
 func init() {
 	log.SetOutput(colorable.NewColorableStdout())
 
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: kemono-scraper download [options]\n\nDownload creators, posts or favorites.\n\nOptions:\n")
+		PrintDefaults()
+	}
 	flag.BoolVar(&help, "help", false, "show all usage")
 	flag.StringVar(&link, "link", "", "download link, should be same site, separate by comma")
 	// if already have link, or creator, site will be ignored
@@ -127,6 +167,7 @@ func init() {
 	flag.IntVar(&updateAfter, "update-after", 0, "--update-after YYYYMMDD, select posts updated after YYYYMMDD")
 	flag.StringVar(&extensionOnly, "extension-only", "", "--extension-only, select posts with only extension, separate by comma (e.g. --extension-only jpg,png)")
 	flag.StringVar(&extensionExclude, "extension-exclude", "", "--extension-exclude, select posts without extension, separate by comma (e.g. --extension-exclude jpg,png)")
+	flag.StringVar(&sincePost, "since-post", "", "--since-post <post id>, select posts newer than this post, the post list is fetched only down to it, best set per creator in the config")
 
 	// download options
 	flag.StringVar(&output, "output", "", "output directory")
@@ -136,6 +177,10 @@ func init() {
 	flag.StringVar(&audioTemplate, "audio-template", "", "audio template, e.g. <ks:creator>/<ks:post>/<ks:filename><ks:extension>")
 	flag.StringVar(&archiveTemplate, "archive-template", "", "archive template, e.g. <ks:creator>/<ks:post>/<ks:filename><ks:extension>")
 	flag.BoolVar(&content, "content", false, "if download post content")
+	flag.BoolVar(&contentFiles, "content-files", true, "download the images and files of the site referenced in the post content too, --content-files=false to skip them, default true")
+	flag.BoolVar(&rewriteContent, "rewrite-content", false, "point the images and links of content.html to the downloaded files, with --content")
+	flag.StringVar(&externalHosts, "external", "", "download the external links of posts to these file hosts into the post directory, separate by comma: pixeldrain, dropbox")
+	flag.StringVar(&exportFormats, "export", "", "write every post after its files are downloaded, separate by comma: json (post.json with the full metadata), markdown (post.md), html (post.html showing the local files), index (index.html of every creator, implies json), links (links.txt and links.json with the external links)")
 	flag.BoolVar(&async, "async", false, "if download posts asynchronously, may cause the file order is not the same as the post order, can be used with --with-prefix-number, default false")
 	flag.StringVar(&maxSize, "max-size", "", "max size, e.g. 10 MB, 1 GB")
 	flag.StringVar(&minSize, "min-size", "", "min size, e.g. 10 MB, 1 GB")
@@ -143,39 +188,42 @@ func init() {
 	flag.BoolVar(&nameRuleOnlyIndex, "name-rule-only-index", false, "if use only index as file name(eg. 1.png, 2.png, ...)")
 	flag.IntVar(&downloadTimeout, "download-timeout", 1800, "download timeout(second), default is 1800s")
 	flag.IntVar(&retry, "retry", 3, "download retry, default is 3")
-	flag.Float64Var(&retryInterval, "retry-interval", 10, "download retry interval(second), default is 10s")
+	flag.Float64Var(&retryInterval, "retry-interval", 10, "first retry interval(second), doubled after each failure with some jitter, a longer Retry-After of the server is honored, default is 10s")
 	flag.IntVar(&maxDownloadParallel, "max-download-parallel", 3, "max download file concurrent, default is 3, async mode only")
-	flag.IntVar(&rateLimit, "rate-limit", 2, "request per second, default is 2")
+	flag.IntVar(&postParallel, "post-parallel", 1, "posts downloaded at the same time, their files share the max-download-parallel workers, more than 1 does not keep the post order, default is 1")
+	flag.Float64Var(&rateLimit, "rate-limit", 2, "file requests per second of each host, can be fractional e.g. 0.5, default is 2")
+	flag.Float64Var(&apiRateLimit, "api-rate-limit", 1, "api requests per second, can be fractional e.g. 0.5, default is 1")
+	flag.IntVar(&rateBurst, "rate-burst", 1, "requests sent at once before the rate limit applies, default is 1")
 	flag.StringVar(&proxy, "proxy", "", "proxy url, e.g. http://proxy.com:8080")
-	_, err := os.Stat("config.yaml")
+	flag.StringVar(&kemonoURL, "kemono-url", "https://kemono.su", "origin of the kemono api and files, e.g. a mirror like http://127.0.0.1:8080")
+	flag.StringVar(&coomerURL, "coomer-url", "https://coomer.su", "origin of the coomer api and files, e.g. a mirror like http://127.0.0.1:8080")
+	flag.StringVar(&reportPath, "report", "", "write a json report with the outcome of every post and file to this path, e.g. report.json")
+	flag.StringVar(&retryFailed, "retry-failed", "", "re-download only the failed files listed in a report written by --report, creator and post lists are not fetched")
+	flag.BoolVar(&dryRun, "dry-run", false, "fetch and filter posts and print what would be downloaded and where, without downloading anything")
+	flag.StringVar(&planFormat, "plan-format", "table", "dry-run output: table or json, default is table")
+	flag.BoolVar(&headSize, "head-size", false, "get the size of every file to download with a HEAD request in dry-run mode")
+	flag.StringVar(&storePath, "store", "", "content addressed store directory, every file is downloaded once and the save paths link to it, e.g. ./store")
+	flag.StringVar(&storeLink, "store-link", "hardlink", "how save paths refer to the store: hardlink, symlink or reflink, default is hardlink")
+	flag.StringVar(&statePath, "state", "", "download state database, posts and files recorded in it are skipped without checking the disk, e.g. state.db")
+	flag.DurationVar(&watchInterval, "watch-interval", 30*time.Minute, "time between two checks of the watch command, e.g. 10m, 2h, default is 30m")
+	flag.Float64Var(&watchJitter, "watch-jitter", 0.1, "the watch interval varies randomly by up to this fraction either way, default is 0.1")
+	configFlags(flag.CommandLine)
+}
 
-	if os.IsNotExist(err) {
-		// file does not exist
-		return
-	}
-	if err != nil {
-		log.Printf("check config.yaml failed, %v", err)
-		return
-	}
-	open, err := os.Open("config.yaml")
-	if err != nil {
-		log.Fatalf("open config file error: %v", err)
-	}
-	defer open.Close()
-	bytes, err := ioutil.ReadAll(open)
-	if err != nil {
-		log.Fatalf("read config file error: %v", err)
-	}
-	err = yaml.Unmarshal(bytes, &config)
-	if err != nil {
-		log.Fatalf("unmarshal config file error: %v", err)
-	}
+// parseGlobalFlags parse the flags of the download subcommand, and fill the ones not passed from the config
+func parseGlobalFlags(args []string) {
+	parseFlags(flag.CommandLine, args)
 }
 
-func setPassedFlags() {
-	flag.Visit(func(f *flag.Flag) {
+// parseFlags parse the flags of a command, then fill the ones not passed from the environment and the config file
+func parseFlags(fs *flag.FlagSet, args []string) {
+	_ = fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
 		passedFlags[f.Name] = true
 	})
+	if err := applyConfig(); err != nil {
+		log.Fatalf("%s", err)
+	}
 }
 
 // PrintDefaults same as flag.PrintDefaults(), but only print the flag with two hyphens and without default value
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/mattn/go-colorable"
+	"gopkg.in/yaml.v3"
+)
+
+// command a subcommand of the cli
+type command struct {
+	name string
+	// summary one line shown in the command list
+	summary string
+	run     func(args []string)
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "download", summary: "download creators, posts or favorites", run: runDownload},
+		{name: "watch", summary: "keep downloading the new posts of creators or favorites", run: runWatch},
+		{name: "list", summary: "list creators or the posts of a creator", run: runList},
+		{name: "favorites", summary: "list favorite creators or posts of your account", run: runFavorites},
+		{name: "verify", summary: "check an existing download tree against the file hashes", run: runVerify},
+		{name: "config", summary: "show the effective configuration", run: runConfig},
+		{name: "cookies", summary: "export the cookies of a site to a cookies.txt file", run: runCookies},
+		{name: "help", summary: "show the help of a command", run: runHelp},
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	// flags without a command, e.g. kemono-scraper --creator fanbox:123, download like before
+	if strings.HasPrefix(args[0], "-") {
+		runDownload(args)
+		return
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(args[1:])
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: kemono-scraper <command> [options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun kemono-scraper help <command> for the options of a command.\n")
+}
+
+// runHelp the help subcommand: kemono-scraper help [command]
+func runHelp(args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		usage()
+		return
+	}
+	cmd.run(append(args[1:], "-h"))
+}
+
+// newFlagSet create the flag set of a subcommand, with its usage line and description, and the config flags.
+// Parse it with parseFlags, so the config gives the values of the shared flags not passed
+func newFlagSet(name, synopsis, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: kemono-scraper %s\n\n%s\n\nOptions:\n", synopsis, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// siteFlags register the flags to reach a site: proxy, mirrors and rate limit
+func siteFlags(fs *flag.FlagSet) {
+	fs.StringVar(&proxy, "proxy", proxy, "proxy url, e.g. http://proxy.com:8080")
+	fs.StringVar(&kemonoURL, "kemono-url", kemonoURL, "origin of the kemono api")
+	fs.StringVar(&coomerURL, "coomer-url", coomerURL, "origin of the coomer api")
+	fs.Float64Var(&apiRateLimit, "api-rate-limit", apiRateLimit, "api requests per second")
+}
+
+// cookieFlags register the flags to read the cookies of the account
+func cookieFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cookieFile, "cookie", cookieFile, "cookie file (cookies.txt format)")
+	fs.StringVar(&cookieBrowser, "cookie-browser", cookieBrowser, "browser to read the cookies from if there is no cookie file, windows only")
+}
+
+// checkSite exit if s is not kemono or coomer
+func checkSite(fs *flag.FlagSet, s string) {
+	if s != Kemono && s != Coomer {
+		fmt.Fprintf(fs.Output(), "invalid site %q, must be kemono or coomer\n", s)
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// newSiteKemono create a Kemono to query the api of the site, logging to stderr
+func newSiteKemono(s string) *kemono.Kemono {
+	if apiRateLimit <= 0 {
+		log.Fatalf("api rate limit must be greater than 0")
+	}
+	options := append(siteDownloaderOptions(s),
+		downloader.APIRateLimit(apiRateLimit),
+		downloader.SetLog(stderrLog{}),
+	)
+	if proxy != "" {
+		options = append(options, downloader.WithProxy(proxy))
+	}
+	return kemono.NewKemono(
+		kemono.WithDomain(s),
+		kemono.WithBaseURL(siteURL(s)),
+		kemono.SetDownloader(downloader.NewDownloader(options...)),
+		kemono.SetLog(stderrLog{}),
+		kemono.SetRetry(retry),
+		kemono.SetRetryInterval(time.Duration(retryInterval*float64(time.Second))),
+	)
+}
+
+// runConfig the config subcommand: kemono-scraper config show
+func runConfig(args []string) {
+	fs := newFlagSet("config", "config show",
+		"Show the effective download options: the flag defaults overridden by the config file, its profile and the KS_* environment variables.")
+	// the options may follow the action, e.g. config show --profile coomer
+	if len(args) == 0 || args[0] != "show" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	parseFlags(fs, args[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	values := make(map[string]interface{})
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if f.Name == "help" || f.Name == "config" || f.Name == "profile" {
+			return
+		}
+		if g, ok := f.Value.(flag.Getter); ok {
+			values[f.Name] = g.Get()
+		} else {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := yaml.NewEncoder(os.Stdout)
+	defer out.Close()
+	// keep the flag order stable
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		var value yaml.Node
+		if err := value.Encode(values[name]); err != nil {
+			log.Fatalf("encode config failed: %s", err)
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &value)
+	}
+	if len(creatorSections) > 0 {
+		var value yaml.Node
+		if err := value.Encode(creatorSections); err != nil {
+			log.Fatalf("encode config failed: %s", err)
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "creators"}, &value)
+	}
+	if loadedConfig != "" {
+		errLog.Printf("config file: %s", loadedConfig)
+	}
+	if profile != "" {
+		errLog.Printf("profile: %s", profile)
+	}
+	if err := out.Encode(node); err != nil {
+		log.Fatalf("encode config failed: %s", err)
+	}
+}
+
+// stderrLog log to stderr without a status bar, so stdout only holds the output of the command
+type stderrLog struct{}
+
+var errLog = log.New(colorable.NewColorableStderr(), "", log.LstdFlags)
+
+func (stderrLog) Printf(format string, v ...interface{}) { errLog.Printf(format, v...) }
+func (stderrLog) Print(s string)                         { errLog.Print(s) }
+func (stderrLog) SetStatus(s []string)                   {}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// configName the name of the config file
+const configName = "config.yaml"
+
+// envPrefix prefix of the environment variables overriding the config, e.g. KS_OUTPUT for --output
+const envPrefix = "KS_"
+
+// CreatorOptions the options a creator section can override, the keys are the flag names.
+// A nil field is not set
+type CreatorOptions struct {
+	First            *int    `yaml:"first,omitempty"`
+	Last             *int    `yaml:"last,omitempty"`
+	Date             *int    `yaml:"date,omitempty"`
+	DateBefore       *int    `yaml:"date-before,omitempty"`
+	DateAfter        *int    `yaml:"date-after,omitempty"`
+	Update           *int    `yaml:"update,omitempty"`
+	UpdateBefore     *int    `yaml:"update-before,omitempty"`
+	UpdateAfter      *int    `yaml:"update-after,omitempty"`
+	ExtensionOnly    *string `yaml:"extension-only,omitempty"`
+	ExtensionExclude *string `yaml:"extension-exclude,omitempty"`
+	SincePost        *string `yaml:"since-post,omitempty"`
+	Template         *string `yaml:"template,omitempty"`
+	ImageTemplate    *string `yaml:"image-template,omitempty"`
+	VideoTemplate    *string `yaml:"video-template,omitempty"`
+	AudioTemplate    *string `yaml:"audio-template,omitempty"`
+	ArchiveTemplate  *string `yaml:"archive-template,omitempty"`
+	Output           *string `yaml:"output,omitempty"`
+	MaxSize          *string `yaml:"max-size,omitempty"`
+	MinSize          *string `yaml:"min-size,omitempty"`
+	Content          *bool   `yaml:"content,omitempty"`
+}
+
+// Options the download options of the config file, the keys are the flag names. A nil field is not set
+type Options struct {
+	CreatorOptions `yaml:",inline"`
+
+	Link                *string        `yaml:"link"`
+	FavSite             *string        `yaml:"fav-site"`
+	Creator             *string        `yaml:"creator"`
+	Banner              *bool          `yaml:"banner"`
+	FavCreator          *bool          `yaml:"fav-creator"`
+	FavPost             *bool          `yaml:"fav-post"`
+	CookieBrowser       *string        `yaml:"cookie-browser"`
+	Cookie              *string        `yaml:"cookie"`
+	Overwrite           *bool          `yaml:"overwrite"`
+	Async               *bool          `yaml:"async"`
+	WithPrefixNumber    *bool          `yaml:"with-prefix-number"`
+	NameRuleOnlyIndex   *bool          `yaml:"name-rule-only-index"`
+	DownloadTimeout     *int           `yaml:"download-timeout"`
+	Retry               *int           `yaml:"retry"`
+	RetryInterval       *float64       `yaml:"retry-interval"`
+	MaxDownloadParallel *int           `yaml:"max-download-parallel"`
+	PostParallel        *int           `yaml:"post-parallel"`
+	RateLimit           *float64       `yaml:"rate-limit"`
+	APIRateLimit        *float64       `yaml:"api-rate-limit"`
+	RateBurst           *int           `yaml:"rate-burst"`
+	Proxy               *string        `yaml:"proxy"`
+	KemonoURL           *string        `yaml:"kemono-url"`
+	CoomerURL           *string        `yaml:"coomer-url"`
+	Report              *string        `yaml:"report"`
+	RetryFailed         *string        `yaml:"retry-failed"`
+	DryRun              *bool          `yaml:"dry-run"`
+	PlanFormat          *string        `yaml:"plan-format"`
+	HeadSize            *bool          `yaml:"head-size"`
+	Store               *string        `yaml:"store"`
+	StoreLink           *string        `yaml:"store-link"`
+	State               *string        `yaml:"state"`
+	Export              *string        `yaml:"export"`
+	ContentFiles        *bool          `yaml:"content-files"`
+	RewriteContent      *bool          `yaml:"rewrite-content"`
+	External            *string        `yaml:"external"`
+	WatchInterval       *time.Duration `yaml:"watch-interval"`
+	WatchJitter         *float64       `yaml:"watch-jitter"`
+}
+
+// Profile a set of options, with the creator sections keyed by <service>:<id>
+type Profile struct {
+	Options `yaml:",inline"`
+
+	Creators map[string]CreatorOptions `yaml:"creators"`
+}
+
+// Config the config file: the default profile, and the named ones selected by --profile
+type Config struct {
+	Profile `yaml:",inline"`
+
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+var (
+	// config file, set by --config or KS_CONFIG
+	configPath string
+	// profile of the config file, set by --profile or KS_PROFILE
+	profile string
+	// config file loaded, empty if there is none
+	loadedConfig string
+	// creator sections of the config, map[<service>:<id>]CreatorOptions
+	creatorSections = make(map[string]CreatorOptions)
+)
+
+// configFlags register the flags selecting the config file and its profile
+func configFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configPath, "config", "", "config file, default is the first of ./config.yaml, $XDG_CONFIG_HOME/kemono-scraper/config.yaml\n"+
+		"and $XDG_CONFIG_DIRS/kemono-scraper/config.yaml, can be set by KS_CONFIG")
+	fs.StringVar(&profile, "profile", "", "profile of the config file applied over its top level options, can be set by KS_PROFILE")
+}
+
+// findConfig return the config file to load, or an empty string if there is none
+func findConfig() (string, error) {
+	if configPath != "" {
+		if _, err := os.Stat(configPath); err != nil {
+			return "", fmt.Errorf("config file error: %w", err)
+		}
+		return configPath, nil
+	}
+	for _, path := range configPaths() {
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("check config file error: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// configPaths the config files looked up in order: the current directory, then the XDG config directories
+func configPaths() []string {
+	paths := []string{configName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "kemono-scraper", configName))
+	}
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		if filepath.IsAbs(dir) {
+			paths = append(paths, filepath.Join(dir, "kemono-scraper", configName))
+		}
+	}
+	return paths
+}
+
+// loadConfig read and validate a config file, unknown keys and values of the wrong type are errors
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) > 0 {
+		if err := validateNode(root.Content[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+// validateNode check the values the yaml types can not, so the errors have line numbers too
+func validateNode(node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "profiles":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if err := validateNode(value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		case "creators":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if _, _, err := parseCreator(value.Content[j].Value); err != nil {
+					return fmt.Errorf("line %d: %w", value.Content[j].Line, err)
+				}
+				if err := validateNode(value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		case "date", "date-before", "date-after", "update", "update-before", "update-after":
+			_, err = parseDate(value.Value)
+		case "plan-format":
+			if value.Value != "table" && value.Value != "json" {
+				err = fmt.Errorf("invalid plan format %s, must be table or json", value.Value)
+			}
+		case "store-link":
+			_, err = downloader.ParseLinkMode(value.Value)
+		case "export":
+			_, err = parseExporters(value.Value)
+		case "external":
+			_, err = parseFetchers(value.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", value.Line, key.Value, err)
+		}
+	}
+	return nil
+}
+
+// applyConfig fill the flags not passed from the environment, the profile and the config file, in this order
+func applyConfig() error {
+	if !passedFlags["config"] {
+		configPath = os.Getenv(envPrefix + "CONFIG")
+	}
+	if !passedFlags["profile"] {
+		profile = os.Getenv(envPrefix + "PROFILE")
+	}
+	path, err := findConfig()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if profile != "" {
+			return fmt.Errorf("profile %s is set, but there is no config file", profile)
+		}
+	} else {
+		config, err := loadConfig(path)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		loadedConfig = path
+		config.Options.apply()
+		for key, o := range config.Creators {
+			creatorSections[key] = o
+		}
+		if profile != "" {
+			p, ok := config.Profiles[profile]
+			if !ok {
+				return fmt.Errorf("config file %s: unknown profile %s, profiles: %s", path, profile, strings.Join(config.profileNames(), ", "))
+			}
+			p.Options.apply()
+			for key, o := range p.Creators {
+				creatorSections[key] = creatorSections[key].merge(o)
+			}
+		}
+	}
+	return applyEnv()
+}
+
+// applyEnv set the flags not passed from the KS_* environment variables, e.g. KS_RETRY_INTERVAL for --retry-interval
+func applyEnv() error {
+	var err error
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if err != nil || passedFlags[f.Name] || f.Name == "help" || f.Name == "config" || f.Name == "profile" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = fmt.Errorf("invalid %s %q: %w", name, value, e)
+		}
+	})
+	return err
+}
+
+// envName the environment variable of a flag, e.g. KS_RETRY_INTERVAL for retry-interval
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply set the flags not passed from the options
+func (o *Options) apply() {
+	setInt("first", &first, o.First)
+	setInt("last", &last, o.Last)
+	setInt("date", &date, o.Date)
+	setInt("date-before", &dateBefore, o.DateBefore)
+	setInt("date-after", &dateAfter, o.DateAfter)
+	setInt("update", &update, o.Update)
+	setInt("update-before", &updateBefore, o.UpdateBefore)
+	setInt("update-after", &updateAfter, o.UpdateAfter)
+	setString("extension-only", &extensionOnly, o.ExtensionOnly)
+	setString("extension-exclude", &extensionExclude, o.ExtensionExclude)
+	setString("since-post", &sincePost, o.SincePost)
+	setString("template", &template, o.Template)
+	setString("image-template", &imageTemplate, o.ImageTemplate)
+	setString("video-template", &videoTemplate, o.VideoTemplate)
+	setString("audio-template", &audioTemplate, o.AudioTemplate)
+	setString("archive-template", &archiveTemplate, o.ArchiveTemplate)
+
+	setString("link", &link, o.Link)
+	setString("fav-site", &site, o.FavSite)
+	setString("creator", &creator, o.Creator)
+	setBool("banner", &banner, o.Banner)
+	setBool("fav-creator", &favoriteCreator, o.FavCreator)
+	setBool("fav-post", &favoritePost, o.FavPost)
+	setString("cookie-browser", &cookieBrowser, o.CookieBrowser)
+	setString("cookie", &cookieFile, o.Cookie)
+	setBool("overwrite", &overwrite, o.Overwrite)
+	setString("output", &output, o.Output)
+	setBool("content", &content, o.Content)
+	setBool("async", &async, o.Async)
+	setString("max-size", &maxSize, o.MaxSize)
+	setString("min-size", &minSize, o.MinSize)
+	setBool("with-prefix-number", &withPrefixNumber, o.WithPrefixNumber)
+	setBool("name-rule-only-index", &nameRuleOnlyIndex, o.NameRuleOnlyIndex)
+	setInt("download-timeout", &downloadTimeout, o.DownloadTimeout)
+	setInt("retry", &retry, o.Retry)
+	setFloat("retry-interval", &retryInterval, o.RetryInterval)
+	setInt("max-download-parallel", &maxDownloadParallel, o.MaxDownloadParallel)
+	setInt("post-parallel", &postParallel, o.PostParallel)
+	setFloat("rate-limit", &rateLimit, o.RateLimit)
+	setFloat("api-rate-limit", &apiRateLimit, o.APIRateLimit)
+	setInt("rate-burst", &rateBurst, o.RateBurst)
+	setString("proxy", &proxy, o.Proxy)
+	setString("kemono-url", &kemonoURL, o.KemonoURL)
+	setString("coomer-url", &coomerURL, o.CoomerURL)
+	setString("report", &reportPath, o.Report)
+	setString("retry-failed", &retryFailed, o.RetryFailed)
+	setBool("dry-run", &dryRun, o.DryRun)
+	setString("plan-format", &planFormat, o.PlanFormat)
+	setBool("head-size", &headSize, o.HeadSize)
+	setString("store", &storePath, o.Store)
+	setString("store-link", &storeLink, o.StoreLink)
+	setString("state", &statePath, o.State)
+	setString("export", &exportFormats, o.Export)
+	setBool("content-files", &contentFiles, o.ContentFiles)
+	setBool("rewrite-content", &rewriteContent, o.RewriteContent)
+	setString("external", &externalHosts, o.External)
+	setDuration("watch-interval", &watchInterval, o.WatchInterval)
+	setFloat("watch-jitter", &watchJitter, o.WatchJitter)
+}
+
+func setString(name string, v, option *string) {
+	if option != nil && !passedFlags[name] {
+		*v = *option
+	}
+}
+
+func setInt(name string, v, option *int) {
+	if option != nil && !passedFlags[name] {
+		*v = *option
+	}
+}
+
+func setBool(name string, v, option *bool) {
+	if option != nil && !passedFlags[name] {
+		*v = *option
+	}
+}
+
+func setFloat(name string, v, option *float64) {
+	if option != nil && !passedFlags[name] {
+		*v = *option
+	}
+}
+
+func setDuration(name string, v, option *time.Duration) {
+	if option != nil && !passedFlags[name] {
+		*v = *option
+	}
+}
+
+// globalCreatorOptions the creator options of the flags, the config and the environment
+func globalCreatorOptions() CreatorOptions {
+	return CreatorOptions{
+		First:            &first,
+		Last:             &last,
+		Date:             &date,
+		DateBefore:       &dateBefore,
+		DateAfter:        &dateAfter,
+		Update:           &update,
+		UpdateBefore:     &updateBefore,
+		UpdateAfter:      &updateAfter,
+		ExtensionOnly:    &extensionOnly,
+		ExtensionExclude: &extensionExclude,
+		SincePost:        &sincePost,
+		Template:         &template,
+		ImageTemplate:    &imageTemplate,
+		VideoTemplate:    &videoTemplate,
+		AudioTemplate:    &audioTemplate,
+		ArchiveTemplate:  &archiveTemplate,
+		Output:           &output,
+		MaxSize:          &maxSize,
+		MinSize:          &minSize,
+		Content:          &content,
+	}
+}
+
+// merge return o with the fields set in override replaced
+func (o CreatorOptions) merge(override CreatorOptions) CreatorOptions {
+	pickInt(&o.First, override.First)
+	pickInt(&o.Last, override.Last)
+	pickInt(&o.Date, override.Date)
+	pickInt(&o.DateBefore, override.DateBefore)
+	pickInt(&o.DateAfter, override.DateAfter)
+	pickInt(&o.Update, override.Update)
+	pickInt(&o.UpdateBefore, override.UpdateBefore)
+	pickInt(&o.UpdateAfter, override.UpdateAfter)
+	pickString(&o.ExtensionOnly, override.ExtensionOnly)
+	pickString(&o.ExtensionExclude, override.ExtensionExclude)
+	pickString(&o.SincePost, override.SincePost)
+	pickString(&o.Template, override.Template)
+	pickString(&o.ImageTemplate, override.ImageTemplate)
+	pickString(&o.VideoTemplate, override.VideoTemplate)
+	pickString(&o.AudioTemplate, override.AudioTemplate)
+	pickString(&o.ArchiveTemplate, override.ArchiveTemplate)
+	pickString(&o.Output, override.Output)
+	pickString(&o.MaxSize, override.MaxSize)
+	pickString(&o.MinSize, override.MinSize)
+	pickBool(&o.Content, override.Content)
+	return o
+}
+
+// hasSavePath report whether a template or the output directory is set
+func (o CreatorOptions) hasSavePath() bool {
+	return o.Template != nil || o.ImageTemplate != nil || o.VideoTemplate != nil || o.AudioTemplate != nil || o.ArchiveTemplate != nil ||
+		o.Output != nil
+}
+
+// hasSizeLimit report whether a size limit is set
+func (o CreatorOptions) hasSizeLimit() bool {
+	return o.MaxSize != nil || o.MinSize != nil
+}
+
+// sizeLimit the min and max size of the options
+func (o CreatorOptions) sizeLimit() (int64, int64) {
+	var min, max int64 = 0, 1<<63 - 1
+	if s := stringValue(o.MinSize); s != "" {
+		min = utils.ParseSize(s)
+	}
+	if s := stringValue(o.MaxSize); s != "" {
+		max = utils.ParseSize(s)
+	}
+	return min, max
+}
+
+// templates the path templates of the options
+func (o CreatorOptions) templates() Templates {
+	return Templates{
+		Default: stringValue(o.Template),
+		Image:   stringValue(o.ImageTemplate),
+		Video:   stringValue(o.VideoTemplate),
+		Audio:   stringValue(o.AudioTemplate),
+		Archive: stringValue(o.ArchiveTemplate),
+	}
+}
+
+func pickInt(v **int, override *int) {
+	if override != nil {
+		*v = override
+	}
+}
+
+func pickString(v **string, override *string) {
+	if override != nil {
+		*v = override
+	}
+}
+
+func pickBool(v **bool, override *bool) {
+	if override != nil {
+		*v = override
+	}
+}
+
+func intValue(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// parseCreator parse a creator of the form <service>:<id>, and return its site
+func parseCreator(s string) (string, kemono.Creator, error) {
+	components := strings.Split(s, ":")
+	if len(components) != 2 || components[1] == "" {
+		return "", kemono.Creator{}, fmt.Errorf("invalid creator %s, must be <service>:<id>", s)
+	}
+	site, ok := kemono.SiteMap[components[0]]
+	if !ok {
+		return "", kemono.Creator{}, fmt.Errorf("invalid creator %s, unknown service %s", s, components[0])
+	}
+	return site, kemono.NewCreator(components[0], components[1]), nil
+}
+
+// parseDate parse a date of the form YYYYMMDD
+func parseDate(s string) (t time.Time, err error) {
+	if len(s) != 8 {
+		return t, fmt.Errorf("invalid date %s, must be YYYYMMDD", s)
+	}
+	t, err = time.Parse("20060102", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %s, must be YYYYMMDD", s)
+	}
+	return t, nil
+}
+
+// sectionKeys the sorted keys of the creator sections
+func sectionKeys() []string {
+	keys := make([]string, 0, len(creatorSections))
+	for key := range creatorSections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
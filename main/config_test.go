@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		flags   map[string]string
+		// err a part of the expected error, empty for none
+		err  string
+		want map[string]string
+	}{
+		{
+			name:   "unknown key",
+			config: "output: out\nbogus: 1\n",
+			err:    "line 2: field bogus not found",
+		},
+		{
+			name:   "wrong type",
+			config: "output: out\nretry: many\n",
+			err:    "line 2: cannot unmarshal !!str `many` into int",
+		},
+		{
+			name:   "invalid value",
+			config: "date: 2023\n",
+			err:    "line 1: date: invalid date 2023",
+		},
+		{
+			name:   "config",
+			config: "output: out\nretry: 5\nwatch-interval: 10m\n",
+			want:   map[string]string{"output": "out", "retry": "5", "watch-interval": "10m0s", "rate-limit": "2"},
+		},
+		{
+			name:    "profile overrides config",
+			config:  "output: out\nretry: 5\nprofiles:\n  fast:\n    output: fast\n    rate-limit: 4\n",
+			profile: "fast",
+			want:    map[string]string{"output": "fast", "retry": "5", "rate-limit": "4"},
+		},
+		{
+			name:    "unknown profile",
+			config:  "output: out\nprofiles:\n  fast:\n    output: fast\n",
+			profile: "slow",
+			err:     "unknown profile slow, profiles: fast",
+		},
+		{
+			name:   "env overrides config",
+			config: "output: out\nretry: 5\n",
+			env:    map[string]string{"KS_OUTPUT": "env", "KS_RETRY_INTERVAL": "0.5"},
+			want:   map[string]string{"output": "env", "retry": "5", "retry-interval": "0.5"},
+		},
+		{
+			name:   "invalid env",
+			config: "output: out\n",
+			env:    map[string]string{"KS_RETRY": "many"},
+			err:    `invalid KS_RETRY "many"`,
+		},
+		{
+			name:   "flag overrides env",
+			config: "output: out\nretry: 5\n",
+			env:    map[string]string{"KS_OUTPUT": "env", "KS_RETRY": "7"},
+			flags:  map[string]string{"output": "flag"},
+			want:   map[string]string{"output": "flag", "retry": "7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlags(t)
+			path := filepath.Join(t.TempDir(), configName)
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			configPath, passedFlags["config"] = path, true
+			profile, passedFlags["profile"] = tt.profile, true
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			for name, value := range tt.flags {
+				if err := flag.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+				passedFlags[name] = true
+			}
+
+			err := applyConfig()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error with %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply config failed: %s", err)
+			}
+			for name, want := range tt.want {
+				if got := flag.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s: expected %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+// resetFlags set the flags of the command back to their defaults, and forget the passed flags and the creator sections
+func resetFlags(t *testing.T) {
+	t.Helper()
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			// the flags of the test binary
+			return
+		}
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("reset %s: %s", f.Name, err)
+		}
+	})
+	passedFlags = make(map[string]bool)
+	creatorSections = make(map[string]CreatorOptions)
+	configPath, profile, loadedConfig = "", "", ""
+}
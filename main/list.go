@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+// runList the list subcommand: kemono-scraper list creators|posts [options]
+func runList(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "creators":
+			listCreators(args[1:])
+			return
+		case "posts":
+			listPosts(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: kemono-scraper list creators|posts [options]\n\n"+
+		"  creators   list the creators of a site\n"+
+		"  posts      list the posts of a creator\n")
+	if len(args) == 0 || (args[0] != "-h" && args[0] != "--help" && args[0] != "-help") {
+		os.Exit(2)
+	}
+}
+
+func listCreators(args []string) {
+	fs := newFlagSet("list creators", "list creators [options]", "List the creators of a site, filtered by service and name.")
+	var (
+		s          string
+		service    string
+		name       string
+		jsonOutput bool
+	)
+	fs.StringVar(&s, "site", Kemono, "site: kemono or coomer")
+	fs.StringVar(&service, "service", "", "only creators of this service, e.g. fanbox")
+	fs.StringVar(&name, "name", "", "only creators whose name contains this text, case insensitive")
+	fs.BoolVar(&jsonOutput, "json", false, "print as json")
+	siteFlags(fs)
+	parseFlags(fs, args)
+	checkSite(fs, s)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	creators, err := newSiteKemono(s).FetchCreatorsContext(ctx)
+	if err != nil {
+		log.Fatalf("list creators failed: %s", err)
+	}
+	var selected []kemono.Creator
+	for _, c := range creators {
+		if service != "" && !strings.EqualFold(c.Service, service) {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(c.Name), strings.ToLower(name)) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+
+	if jsonOutput {
+		printJSON(selected)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tID\tUPDATED\tFAVORITED\tNAME")
+	for _, c := range selected {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", c.Service, c.Id, formatDate(c.Updated.Time), c.Favorited, c.Name)
+	}
+	_ = tw.Flush()
+}
+
+func listPosts(args []string) {
+	fs := newFlagSet("list posts", "list posts --creator <service>:<id> | --link <url> [options]", "List the posts of a creator, newest first.")
+	var (
+		creatorFlag string
+		linkFlag    string
+		jsonOutput  bool
+	)
+	fs.StringVar(&creatorFlag, "creator", "", "creator, <service>:<id>")
+	fs.StringVar(&linkFlag, "link", "", "creator link, e.g. https://kemono.su/fanbox/user/123")
+	fs.BoolVar(&jsonOutput, "json", false, "print as json")
+	siteFlags(fs)
+	parseFlags(fs, args)
+
+	var s, service, id string
+	switch {
+	case creatorFlag != "" && linkFlag == "":
+		parts := strings.Split(creatorFlag, ":")
+		if len(parts) != 2 {
+			log.Fatalf("invalid creator %s", creatorFlag)
+		}
+		var ok bool
+		if s, ok = kemono.SiteMap[parts[0]]; !ok {
+			log.Fatalf("invalid creator %s", creatorFlag)
+		}
+		service, id = parts[0], parts[1]
+	case linkFlag != "" && creatorFlag == "":
+		s, service, id, _, _ = parasLink(linkFlag)
+		if service == "discord" {
+			log.Fatal("listing the messages of a discord server is not supported")
+		}
+	default:
+		fmt.Fprintf(fs.Output(), "one of --creator or --link is required\n")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	posts, err := newSiteKemono(s).FetchPostsContext(ctx, service, id)
+	if err != nil {
+		log.Fatalf("list posts failed: %s", err)
+	}
+
+	if jsonOutput {
+		printJSON(posts)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPUBLISHED\tEDITED\tFILES\tTITLE")
+	for _, p := range posts {
+		files := len(p.Attachments)
+		if p.File.Path != "" {
+			files++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", p.Id, formatDate(p.Published), formatDate(p.Edited), files, p.Title)
+	}
+	_ = tw.Flush()
+}
+
+// runFavorites the favorites subcommand: kemono-scraper favorites [options]
+func runFavorites(args []string) {
+	fs := newFlagSet("favorites", "favorites [options]",
+		"List the favorite creators or posts of your account, the cookies are read like the download command does.\n"+
+			"To download them use: kemono-scraper download --fav-site <site> --fav-creator|--fav-post")
+	var (
+		s          string
+		typ        string
+		jsonOutput bool
+	)
+	fs.StringVar(&s, "site", Kemono, "site: kemono or coomer")
+	fs.StringVar(&typ, "type", "creators", "creators or posts")
+	fs.BoolVar(&jsonOutput, "json", false, "print as json")
+	fs.StringVar(&proxy, "proxy", proxy, "proxy url, e.g. http://proxy.com:8080")
+	fs.StringVar(&kemonoURL, "kemono-url", kemonoURL, "origin of the kemono api")
+	fs.StringVar(&coomerURL, "coomer-url", coomerURL, "origin of the coomer api")
+	cookieFlags(fs)
+	parseFlags(fs, args)
+	checkSite(fs, s)
+	if typ != "creators" && typ != "posts" {
+		fmt.Fprintf(fs.Output(), "invalid type %q, must be creators or posts\n", typ)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cookies := siteCookies(s)
+	if len(cookies) == 0 {
+		log.Fatal("cookie is empty")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	if typ == "creators" {
+		creators := fetchFavoriteCreators(s, cookies)
+		if jsonOutput {
+			printJSON(creators)
+			return
+		}
+		fmt.Fprintln(tw, "SERVICE\tID\tNAME")
+		for _, c := range creators {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Service, c.Id, c.Name)
+		}
+		return
+	}
+	posts := fetchFavoritePosts(s, cookies)
+	if jsonOutput {
+		printJSON(posts)
+		return
+	}
+	fmt.Fprintln(tw, "SERVICE\tUSER\tID\tTITLE")
+	for _, p := range posts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Service, p.User, p.Id, p.Title)
+	}
+}
+
+// runCookies the cookies subcommand: kemono-scraper cookies export [options]
+func runCookies(args []string) {
+	fs := newFlagSet("cookies", "cookies export [options]",
+		"Export the cookies of a site, read from the browser (windows) or a cookie file, in the cookies.txt format used by --cookie.")
+	var (
+		s   string
+		out string
+	)
+	// read from the browser unless a cookie file is given
+	cookieFile = ""
+	fs.StringVar(&s, "site", Kemono, "site: kemono or coomer")
+	fs.StringVar(&out, "output", "-", "output file, - for stdout")
+	cookieFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || fs.Arg(0) != "export" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	checkSite(fs, s)
+
+	cookies := siteCookies(s)
+	if len(cookies) == 0 {
+		log.Fatal("cookie is empty")
+	}
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatalf("create cookie file failed: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeCookieFile(w, cookies); err != nil {
+		log.Fatalf("write cookie file failed: %s", err)
+	}
+}
+
+// siteCookies read the cookies of the site
+func siteCookies(s string) []*http.Cookie {
+	// the cookie file is filtered by the site
+	site = s
+	return getCookies(s)
+}
+
+// writeCookieFile write cookies in the cookies.txt format read by parasCookieFile
+func writeCookieFile(w io.Writer, cookies []*http.Cookie) error {
+	if _, err := fmt.Fprintln(w, "# Netscape HTTP Cookie File"); err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			c.Domain, boolString(strings.HasPrefix(c.Domain, ".")), path, boolString(c.Secure), expires, c.Name, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolString(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02")
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("encode json failed: %s", err)
+	}
+	fmt.Println(string(data))
+}
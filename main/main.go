@@ -5,21 +5,26 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
-	tmpl "text/template"
+	"syscall"
 	"time"
 
 	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/export"
+	"github.com/elvis972602/kemono-scraper/external"
 	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/state"
 	"github.com/elvis972602/kemono-scraper/term"
 	"github.com/elvis972602/kemono-scraper/utils"
 	"github.com/mattn/go-colorable"
@@ -38,28 +43,26 @@ var (
 	downloaderOptions      []downloader.DownloadOption
 	hasLink                bool
 	s, srv, userId, postId string
-	// map[<Creator>][]<postId>
-	idFilter map[string]map[kemono.Creator][]string
+	channel                string
+	// keep checking the creators for new posts instead of downloading them once
+	watchMode bool
+	// map[<site>][]WatchOption
+	watchOptions map[string][]kemono.WatchOption
 )
 
 func init() {
-	idFilter = make(map[string]map[kemono.Creator][]string)
-	idFilter[Kemono] = make(map[kemono.Creator][]string)
-	idFilter[Coomer] = make(map[kemono.Creator][]string)
 	options = make(map[string][]kemono.Option)
+	watchOptions = make(map[string][]kemono.WatchOption)
 }
 
-func main() {
-	flag.Parse()
-	setPassedFlags()
-
+// runDownload the download subcommand, it takes the global flags
+func runDownload(args []string) {
+	parseGlobalFlags(args)
 	if help {
-		PrintDefaults()
+		flag.CommandLine.Usage()
 		return
 	}
 
-	setFlag()
-
 	if creator != "" {
 		creatorComponents := strings.Split(creator, ",")
 		for _, c := range creatorComponents {
@@ -87,37 +90,54 @@ func main() {
 		hasLink = true
 		users := make(map[string][]string)
 		for _, l := range links {
-			s, srv, userId, postId = parasLink(l)
+			s, srv, userId, postId, channel = parasLink(l)
 
 			cs := kemono.NewCreator(srv, userId)
-			users[s] = append(users[s], srv, userId)
-			if postId != "" {
-				idFilter[s][cs] = append(idFilter[s][cs], postId)
+			switch {
+			case srv == "discord":
+				if channel != "" {
+					options[s] = append(options[s], kemono.WithDiscordChannels(userId, channel))
+				} else {
+					options[s] = append(options[s], kemono.WithDiscordChannels(userId))
+				}
+			case postId != "":
+				// fetched with a single request, a --creator of the same creator still gets all its posts
+				options[s] = append(options[s], kemono.WithUserPosts(cs, postId))
+			default:
+				users[s] = append(users[s], srv, userId)
+				options[s] = append(options[s],
+					kemono.WithUsersPair(users[s]...),
+				)
 			}
-			options[s] = append(options[s],
-				kemono.WithUsersPair(users[s]...),
-			)
 		}
 	}
 
 	// check creator
 	if len(creators) > 0 {
 		for _, c := range creators {
-			creatorComponents := strings.Split(c, ":")
-			if len(creatorComponents) != 2 {
-				log.Fatalf("invalid creator %s", c)
-			}
-			s, ok := kemono.SiteMap[creatorComponents[0]]
-			if !ok {
-				log.Fatalf("invalid creator %s", c)
+			s, u, err := parseCreator(c)
+			if err != nil {
+				log.Fatalf("%s", err)
 			}
-			options[s] = append(options[s], kemono.WithUsersPair(creatorComponents[0], creatorComponents[1]))
-
+			options[s] = append(options[s], kemono.WithUsers(u))
 		}
-	} else if !hasLink && !favoriteCreator && !favoritePost {
+	} else if !hasLink && !favoriteCreator && !favoritePost && retryFailed == "" {
 		log.Fatal("creator is empty")
 	}
 
+	// failed files of a previous run, map[<site>][]Failure
+	failures := make(map[string][]kemono.Failure)
+	if retryFailed != "" {
+		fs, err := kemono.ReadFailures(retryFailed)
+		if err != nil {
+			log.Fatalf("read failed files failed: %s", err)
+		}
+		for _, f := range fs {
+			failures[f.Site] = append(failures[f.Site], f)
+		}
+		log.Printf("retry %d failed files from %s", len(fs), retryFailed)
+	}
+
 	if favoriteCreator || favoritePost {
 		if site == "" {
 			log.Fatal("fav-site is empty")
@@ -132,208 +152,103 @@ func main() {
 			if len(cs) == 0 {
 				log.Fatal("cookie is empty")
 			}
-			if favoriteCreator {
+			if favoriteCreator && watchMode {
+				// fetched again before every check, to follow the favorites added later
+				watchOptions[siteComponent] = append(watchOptions[siteComponent], favoriteUsers(siteComponent, cs))
+			} else if favoriteCreator {
 				for _, c := range fetchFavoriteCreators(siteComponent, cs) {
 					options[siteComponent] = append(options[siteComponent], kemono.WithUsersPair(c.Service, c.Id))
 				}
 			}
 			if favoritePost {
 				for _, c := range fetchFavoritePosts(siteComponent, cs) {
-					u := kemono.NewCreator(c.Service, c.User)
-					options[siteComponent] = append(options[siteComponent], kemono.WithUsers(u))
-					idFilter[siteComponent][u] = append(idFilter[siteComponent][u], c.Id)
+					// fetched with a single request each
+					options[siteComponent] = append(options[siteComponent], kemono.WithUserPosts(kemono.NewCreator(c.Service, c.User), c.Id))
 				}
 			}
 		}
 	}
 
-	for k, v := range idFilter {
-		for u, ids := range v {
-			if len(ids) > 0 {
-				options[k] = append(options[k], kemono.WithUserPostFilter(u,
-					kemono.IdFilter(ids...),
-				))
-			}
-		}
-	}
-
 	// banner
 	sharedOptions = append(sharedOptions, kemono.WithBanner(banner))
 
 	// overwrite
 	downloaderOptions = append(downloaderOptions, downloader.OverWrite(overwrite))
 
-	// check first
-	if first != 0 {
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.NumbFilter(func(i int) bool {
-				if i <= first {
-					return true
-				}
-				return false
-			}),
-		))
-	}
-
-	// check last
-	if last != 0 {
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.NumbFilter(func(i int) bool {
-				if i >= last {
-					return true
-				}
-				return false
-			}),
-		))
-	}
-
-	if date != 0 {
-		t := parasData(strconv.Itoa(date))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.ReleaseDateFilter(t.Add(-1), t.Add(24*time.Hour-1)),
-		))
-	}
-
-	if dateBefore != 0 {
-		t := parasData(strconv.Itoa(dateBefore))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.ReleaseDateBeforeFilter(t),
-		))
-	}
-
-	if dateAfter != 0 {
-		t := parasData(strconv.Itoa(dateAfter))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.ReleaseDateAfterFilter(t),
-		))
-	}
-
-	if update != 0 {
-		t := parasData(strconv.Itoa(update))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.EditDateFilter(t.Add(-1), t.Add(24*time.Hour-1)),
-		))
-	}
-
-	if updateBefore != 0 {
-		t := parasData(strconv.Itoa(updateBefore))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.EditDateBeforeFilter(t),
-		))
-	}
-
-	if updateAfter != 0 {
-		t := parasData(strconv.Itoa(updateAfter))
-		sharedOptions = append(sharedOptions, kemono.WithPostFilter(
-			kemono.EditDateAfterFilter(t),
-		))
-	}
-
-	// check extensionOnly
-	if extensionOnly != "" {
-		extensionComponents := strings.Split(extensionOnly, ",")
-		// check extension has dot
-		for i, extension := range extensionComponents {
-			extension = strings.TrimSpace(extension)
-			if !strings.HasPrefix(extension, ".") {
-				extensionComponents[i] = "." + extension
-			}
-		}
-		sharedOptions = append(sharedOptions, kemono.WithAttachmentFilter(
-			kemono.ExtensionFilter(extensionComponents...),
-		))
-	}
-
-	// check extensionExclude
-	if extensionExclude != "" {
-		extensionComponents := strings.Split(extensionExclude, ",")
-		// check extension has dot
-		for i, extension := range extensionComponents {
-			extension = strings.TrimSpace(extension)
-			if !strings.HasPrefix(extension, ".") {
-				extensionComponents[i] = "." + extension
-			}
+	// post and attachment filters, a creator section of the config replaces them for its creator
+	global := globalCreatorOptions()
+	sharedOptions = append(sharedOptions,
+		kemono.WithPostFilter(postFilters(global)...),
+		kemono.WithPostCutoff(postCutoffs(global)...),
+		kemono.WithAttachmentFilter(attachmentFilters(global)...),
+	)
+	for _, key := range sectionKeys() {
+		_, u, err := parseCreator(key)
+		if err != nil {
+			log.Fatalf("%s", err)
 		}
-		sharedOptions = append(sharedOptions, kemono.WithAttachmentFilter(
-			kemono.ExtensionExcludeFilter(extensionComponents...),
-		))
+		o := global.merge(creatorSections[key])
+		sharedOptions = append(sharedOptions,
+			kemono.WithUserFilterOverride(u),
+			kemono.WithUserPostFilter(u, postFilters(o)...),
+			kemono.WithUserPostCutoff(u, postCutoffs(o)...),
+			kemono.WithUserAttachmentFilter(u, attachmentFilters(o)...),
+		)
 	}
 
 	if output == "" {
 		output = "./download"
 	}
 
-	if template == "" {
-		if imageTemplate != "" || videoTemplate != "" || audioTemplate != "" || archiveTemplate != "" {
-			log.Printf("to use image/video/audio/archive template, you must set template first")
-		}
-		var t *tmpl.Template
-		defaultTemp, err := LoadPathTmpl(TmplDefault, output)
+	defaultSavePath, err := newSavePath(global.templates(), output)
+	if err != nil {
+		log.Fatalf("load template failed: %s", err)
+	}
+	// map[<service>:<id>]SavePathFunc of the creators with their own templates or output directory
+	savePaths := make(map[string]SavePathFunc)
+	for _, key := range sectionKeys() {
+		section := creatorSections[key]
+		_, u, err := parseCreator(key)
 		if err != nil {
-			log.Fatalf("load template failed: %s", err)
+			log.Fatalf("%s", err)
 		}
-		if nameRuleOnlyIndex {
-			t, err = LoadPathTmpl(TmplIndexNumber, output)
-			if err != nil {
-				log.Fatalf("load template failed: %s", err)
-			}
-		} else if withPrefixNumber {
-			t, err = LoadPathTmpl(TmplWithPrefixNumber, output)
+		o := global.merge(section)
+		if section.hasSavePath() {
+			savePath, err := newSavePath(o.templates(), stringValue(o.Output))
 			if err != nil {
-				log.Fatalf("load template failed: %s", err)
-			}
-		} else {
-			t = defaultTemp
-		}
-		downloaderOptions = append(downloaderOptions, downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
-			ext := filepath.Ext(attachment.Name)
-			filehash := filepath.Base(attachment.Path)[0 : len(filepath.Base(attachment.Path))-len(filepath.Ext(attachment.Path))]
-			filename := attachment.Name[0 : len(attachment.Name)-len(ext)]
-			// use Path extension if Name extension is empty
-			if ext == "" {
-				ext = filepath.Ext(attachment.Path)
-			}
-			pathConfig := &PathConfig{
-				Service:   creator.Service,
-				Creator:   utils.ValidDirectoryName(creator.Name),
-				Post:      utils.ValidDirectoryName(DirectoryName(post)),
-				Index:     i,
-				Filename:  utils.ValidDirectoryName(filename),
-				Filehash:  utils.ValidDirectoryName(filehash),
-				Extension: ext,
-			}
-			if ext == ".zip" || ext == ".rar" || ext == ".7z" {
-				return ExecutePathTmpl(defaultTemp, pathConfig)
-			} else {
-				return ExecutePathTmpl(t, pathConfig)
-			}
-		}))
-	} else {
-		tmplCache := NewTmplCache()
-		tmplCache.init()
-
-		downloaderOptions = append(downloaderOptions, downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
-			ext := filepath.Ext(attachment.Name)
-			filehash := filepath.Base(attachment.Path)[0 : len(filepath.Base(attachment.Path))-len(filepath.Ext(attachment.Path))]
-			filename := attachment.Name[0 : len(attachment.Name)-len(ext)]
-			// use Path extension if Name extension is empty
-			if ext == "" {
-				ext = filepath.Ext(attachment.Path)
-			}
-			pathConfig := &PathConfig{
-				Service:   creator.Service,
-				Creator:   utils.ValidDirectoryName(creator.Name),
-				Post:      utils.ValidDirectoryName(DirectoryName(post)),
-				Index:     i,
-				Filename:  utils.ValidDirectoryName(filename),
-				Filehash:  utils.ValidDirectoryName(filehash),
-				Extension: ext,
+				log.Fatalf("load template of %s failed: %s", key, err)
 			}
-			return tmplCache.Execute(getTyp(ext), pathConfig)
-		}))
+			savePaths[key] = savePath
+		}
+		if section.hasSizeLimit() {
+			min, max := o.sizeLimit()
+			downloaderOptions = append(downloaderOptions, downloader.WithUserSizeLimit(u, min, max))
+		}
+		if section.Content != nil {
+			downloaderOptions = append(downloaderOptions, downloader.WithUserContent(u, *section.Content))
+		}
 	}
+	downloaderOptions = append(downloaderOptions, downloader.SavePath(func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+		if savePath, ok := savePaths[creator.PairString()]; ok {
+			return savePath(creator, post, i, attachment)
+		}
+		return defaultSavePath(creator, post, i, attachment)
+	}))
 
-	downloaderOptions = append(downloaderOptions, downloader.WithContent(content))
+	downloaderOptions = append(downloaderOptions, downloader.WithContent(content), downloader.WithRewriteContent(rewriteContent))
+	sharedOptions = append(sharedOptions, kemono.WithContentFiles(contentFiles))
+
+	exporters, err := parseExporters(exportFormats)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	downloaderOptions = append(downloaderOptions, downloader.WithExporter(exporters...))
+
+	fetchers, err := parseFetchers(externalHosts)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	downloaderOptions = append(downloaderOptions, downloader.WithExternalFetcher(fetchers...))
 
 	if maxSize != "" {
 		size := utils.ParseSize(maxSize)
@@ -361,8 +276,8 @@ func main() {
 	if retryInterval < 0 {
 		log.Fatalf("retry interval must be greater than 0")
 	} else {
-		downloaderOptions = append(downloaderOptions, downloader.RetryInterval(time.Duration(retryInterval)*time.Second))
-		sharedOptions = append(sharedOptions, kemono.SetRetryInterval(time.Duration(retryInterval)*time.Second))
+		downloaderOptions = append(downloaderOptions, downloader.RetryInterval(time.Duration(retryInterval*float64(time.Second))))
+		sharedOptions = append(sharedOptions, kemono.SetRetryInterval(time.Duration(retryInterval*float64(time.Second))))
 	}
 
 	// check maxDownloadGoroutine
@@ -372,21 +287,111 @@ func main() {
 		downloaderOptions = append(downloaderOptions, downloader.MaxConcurrent(maxDownloadParallel))
 	}
 
+	if postParallel <= 0 {
+		log.Fatalf("post-parallel must be greater than 0")
+	} else {
+		sharedOptions = append(sharedOptions, kemono.WithPostConcurrency(postParallel))
+	}
+
 	if rateLimit <= 0 {
 		log.Fatalf("rate limit must be greater than 0")
 	} else {
 		downloaderOptions = append(downloaderOptions, downloader.RateLimit(rateLimit))
 	}
 
+	if apiRateLimit <= 0 {
+		log.Fatalf("api rate limit must be greater than 0")
+	} else {
+		downloaderOptions = append(downloaderOptions, downloader.APIRateLimit(apiRateLimit))
+	}
+
+	if rateBurst <= 0 {
+		log.Fatalf("rate burst must be greater than 0")
+	} else {
+		downloaderOptions = append(downloaderOptions, downloader.RateBurst(rateBurst))
+	}
+
 	if proxy != "" {
 		downloaderOptions = append(downloaderOptions, downloader.WithProxy(proxy))
 	}
 
-	ctx := context.Background()
-	defer ctx.Done()
+	if watchMode {
+		if dryRun || retryFailed != "" {
+			log.Fatalf("watch can not be used with dry-run or retry-failed")
+		}
+		if watchInterval <= 0 {
+			log.Fatalf("watch interval must be greater than 0")
+		}
+		if watchJitter < 0 || watchJitter >= 1 {
+			log.Fatalf("watch jitter must be between 0 and 1")
+		}
+	}
+
+	if dryRun {
+		if retryFailed != "" {
+			log.Fatalf("dry-run can not be used with retry-failed")
+		}
+		if planFormat != "table" && planFormat != "json" {
+			log.Fatalf("invalid plan format %s, must be table or json", planFormat)
+		}
+		sharedOptions = append(sharedOptions, kemono.WithDryRun(true))
+		downloaderOptions = append(downloaderOptions, downloader.WithHeadSize(headSize))
+	}
+
+	if statePath != "" {
+		var (
+			db  *state.DB
+			err error
+		)
+		if dryRun {
+			// a dry run reads the state of the previous runs, without creating or writing it
+			db, err = state.OpenReadOnly(statePath)
+		} else {
+			db, err = state.Open(statePath)
+		}
+		switch {
+		case dryRun && errors.Is(err, os.ErrNotExist):
+			log.Printf("state %s not found, nothing downloaded before", statePath)
+		case err != nil:
+			log.Fatalf("open state failed: %s", err)
+		default:
+			defer db.Close()
+			downloaderOptions = append(downloaderOptions, downloader.WithState(db))
+			sharedOptions = append(sharedOptions, kemono.WithState(db))
+		}
+	}
+
+	if storePath != "" {
+		mode, err := downloader.ParseLinkMode(storeLink)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		store, err := downloader.NewStore(storePath, mode)
+		if err != nil {
+			log.Fatalf("open store failed: %s", err)
+		}
+		downloaderOptions = append(downloaderOptions, downloader.WithStore(store))
+	}
+
+	// stop the download on Ctrl-C or SIGTERM, in-flight files keep their partial .tmp to resume next time
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// print the plans of a dry run after the terminal is stopped
+	var plans []*kemono.Plan
+	defer func() {
+		if dryRun {
+			printPlans(plans)
+		}
+	}()
 
+	termCtx, termCancel := context.WithCancel(context.Background())
 	terminal := term.NewTerminal(colorable.NewColorableStdout(), colorable.NewColorableStderr(), false)
-	go terminal.Run(ctx)
+	go terminal.Run(termCtx)
+	defer func() {
+		termCancel()
+		<-terminal.Done()
+	}()
 
 	downloaderOptions = append(downloaderOptions, downloader.SetLog(terminal))
 	sharedOptions = append(sharedOptions, kemono.SetLog(terminal))
@@ -400,279 +405,398 @@ func main() {
 	)
 
 	// Kemono
-	if len(options[Kemono]) > 0 {
+	if len(options[Kemono]) > 0 || len(failures[Kemono]) > 0 || len(watchOptions[Kemono]) > 0 {
 		k = true
 		options[Kemono] = append(options[Kemono], sharedOptions...)
-		options[Kemono] = append(options[Kemono], kemono.WithDomain("kemono"))
-		downloaderOptions = append(downloaderOptions, downloader.BaseURL("https://kemono.su"))
-		token, err := utils.GenerateToken(16)
-		if err != nil {
-			log.Fatalf("generate token failed: %s", err)
-		}
-		downloaderOptions = append(downloaderOptions, downloader.WithCookie([]*http.Cookie{
-			{
-				Name:   "__ddg2",
-				Value:  token,
-				Path:   "/",
-				Domain: ".kemono.su",
-				Secure: false,
-			},
-		}))
-		downloaderOptions = append(downloaderOptions, downloader.WithHeader(downloader.Header{
-			"Host":                      "kemono.su",
-			"User-Agent":                downloader.UserAgent,
-			"Referer":                   "https://kemono.su",
-			"Accept":                    downloader.Accept,
-			"Accept-Language":           downloader.AcceptLanguage,
-			"Accept-Encoding":           downloader.AcceptEncoding,
-			"Sec-Ch-Ua":                 downloader.SecChUA,
-			"Sec-Ch-Ua-Mobile":          downloader.SecChUAMobile,
-			"Sec-Fetch-Dest":            downloader.SecFetchDest,
-			"Sec-Fetch-Mode":            downloader.SecFetchMode,
-			"Sec-Fetch-Site":            downloader.SecFetchSite,
-			"Sec-Fetch-User":            downloader.SecFetchUser,
-			"Upgrade-Insecure-Requests": downloader.UpgradeInsecureRequests,
-			"Connection":                "keep-alive",
-		}))
-		KemonoDownloader = downloader.NewDownloader(downloaderOptions...)
+		options[Kemono] = append(options[Kemono], kemono.WithDomain("kemono"), kemono.WithBaseURL(siteURL(Kemono)))
+		KemonoDownloader = downloader.NewDownloader(append(siteDownloaderOptions(Kemono), downloaderOptions...)...)
 		options[Kemono] = append(options[Kemono], kemono.SetDownloader(KemonoDownloader))
 		KKemono = kemono.NewKemono(options[Kemono]...)
 	}
-	if len(options[Coomer]) > 0 {
+	if len(options[Coomer]) > 0 || len(failures[Coomer]) > 0 || len(watchOptions[Coomer]) > 0 {
 		c = true
 		options[Coomer] = append(options[Coomer], sharedOptions...)
-		options[Coomer] = append(options[Coomer], kemono.WithDomain("coomer"))
-		downloaderOptions = append(downloaderOptions, downloader.BaseURL("https://coomer.su"))
-		token, err := utils.GenerateToken(16)
-		if err != nil {
-			log.Fatalf("generate token failed: %s", err)
-		}
-		downloaderOptions = append(downloaderOptions, downloader.WithCookie([]*http.Cookie{
-			{
-				Name:   "__ddg2",
-				Value:  token,
-				Path:   "/",
-				Domain: ".coomer.su",
-			},
-		}))
-		downloaderOptions = append(downloaderOptions, downloader.WithHeader(downloader.Header{
-			"Host":                      "coomer.su",
-			"User-Agent":                downloader.UserAgent,
-			"Referer":                   "https://coomer.su/",
-			"Accept":                    downloader.Accept,
-			"Accept-Language":           downloader.AcceptLanguage,
-			"Accept-Encoding":           downloader.AcceptEncoding,
-			"Sec-Ch-Ua":                 downloader.SecChUA,
-			"Sec-Ch-Ua-Mobile":          downloader.SecChUAMobile,
-			"Sec-Fetch-Dest":            downloader.SecFetchDest,
-			"Sec-Fetch-Mode":            downloader.SecFetchMode,
-			"Sec-Fetch-Site":            downloader.SecFetchSite,
-			"Sec-Fetch-User":            downloader.SecFetchUser,
-			"Upgrade-Insecure-Requests": downloader.UpgradeInsecureRequests,
-			"Connection":                "keep-alive",
-		}))
-		CoomerDownloader = downloader.NewDownloader(downloaderOptions...)
+		options[Coomer] = append(options[Coomer], kemono.WithDomain("coomer"), kemono.WithBaseURL(siteURL(Coomer)))
+		CoomerDownloader = downloader.NewDownloader(append(siteDownloaderOptions(Coomer), downloaderOptions...)...)
 		options[Coomer] = append(options[Coomer], kemono.SetDownloader(CoomerDownloader))
 		options[Coomer] = append(options[Coomer], kemono.WithBanner(true))
 		KCoomer = kemono.NewKemono(options[Coomer]...)
 	}
 
+	// stop the rate limiters of the downloaders
+	defer func() {
+		for _, d := range []kemono.Downloader{KemonoDownloader, CoomerDownloader} {
+			if closer, ok := d.(io.Closer); ok {
+				_ = closer.Close()
+			}
+		}
+	}()
+
+	var reports []*kemono.Report
+	defer func() {
+		if reportPath == "" {
+			return
+		}
+		if err := writeReports(reportPath, reports); err != nil {
+			log.Printf("write report failed: %s", err)
+		}
+	}()
+
+	if watchMode {
+		sites := make(map[string]*kemono.Kemono)
+		if k {
+			sites[Kemono] = KKemono
+		}
+		if c {
+			sites[Coomer] = KCoomer
+		}
+		reports = watchSites(ctx, terminal, sites)
+		return
+	}
+
 	if k {
 		terminal.Print("Downloading Kemono")
-		err := KKemono.Start()
+		var err error
+		if retryFailed != "" {
+			err = KKemono.RetryFailedContext(ctx, failures[Kemono])
+		} else {
+			err = KKemono.StartContext(ctx)
+		}
+		if r := KKemono.Report(); r != nil {
+			reports = append(reports, r)
+		}
+		if p := KKemono.Plan(); p != nil {
+			plans = append(plans, p)
+		}
 		if err != nil {
+			if isCancelled(err) {
+				terminal.Print("Download cancelled")
+				return
+			}
 			log.Printf("kemono start failed: %s", err)
 		}
 	}
 	if c {
 		terminal.Print("Downloading Coomer")
-		err := KCoomer.Start()
+		var err error
+		if retryFailed != "" {
+			err = KCoomer.RetryFailedContext(ctx, failures[Coomer])
+		} else {
+			err = KCoomer.StartContext(ctx)
+		}
+		if r := KCoomer.Report(); r != nil {
+			reports = append(reports, r)
+		}
+		if p := KCoomer.Plan(); p != nil {
+			plans = append(plans, p)
+		}
 		if err != nil {
+			if isCancelled(err) {
+				terminal.Print("Download cancelled")
+				return
+			}
 			log.Printf("coomer start failed: %s", err)
 		}
 	}
 }
 
-func parasLink(link string) (s, service, userId, postId string) {
+// printPlans print the plans of all sites as tables, or as a json array
+func printPlans(plans []*kemono.Plan) {
+	if planFormat == "json" {
+		data, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			log.Printf("encode plan failed: %s", err)
+			return
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for _, p := range plans {
+		if err := p.WriteTable(os.Stdout); err != nil {
+			log.Printf("print plan failed: %s", err)
+		}
+	}
+}
+
+// writeReports write the reports of all sites to path as a json array
+func writeReports(path string, reports []*kemono.Report) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func isCancelled(err error) bool {
+	var cancelled *kemono.CancelledError
+	return errors.As(err, &cancelled)
+}
+
+// parasLink parse a link to a creator, a post, or a discord server or channel
+func parasLink(link string) (s, service, userId, postId, channel string) {
 	u, err := url.Parse(link)
 	if err != nil {
 		log.Fatal("invalid url")
 	}
 
-	pattern := `(?i)^(?:.*\.)?(kemono|coomer)\.su$`
-	re := regexp.MustCompile(pattern)
+	// links to a configured mirror
+	for _, site := range []string{Kemono, Coomer} {
+		if strings.EqualFold(u.Host, siteHost(site)) {
+			s = site
+		}
+	}
 
-	matchedSubstrings := re.FindStringSubmatch(u.Host)
+	if s == "" {
+		pattern := `(?i)^(?:.*\.)?(kemono|coomer)\.su$`
+		re := regexp.MustCompile(pattern)
 
-	if matchedSubstrings == nil {
-		log.Fatal("invalid host:", u.Host)
-	}
+		matchedSubstrings := re.FindStringSubmatch(u.Host)
 
-	s = matchedSubstrings[1]
+		if matchedSubstrings == nil {
+			log.Fatal("invalid host:", u.Host)
+		}
 
-	pathComponents := strings.Split(u.Path, "/")
-	if len(pathComponents) != 6 && len(pathComponents) != 4 {
-		log.Fatal("Error splitting host component:", pathComponents, len(pathComponents))
-		return
+		s = strings.ToLower(matchedSubstrings[1])
 	}
-	if len(pathComponents) == 6 {
+
+	pathComponents := strings.Split(strings.TrimSuffix(u.Path, "/"), "/")
+	switch {
+	case len(pathComponents) >= 4 && len(pathComponents) <= 5 && pathComponents[1] == "discord" && pathComponents[2] == "server":
+		// /discord/server/{server}, /discord/server/{server}/{channel} or /discord/server/{server}#{channel}
+		service = pathComponents[1]
+		userId = pathComponents[3]
+		channel = u.Fragment
+		if len(pathComponents) == 5 {
+			channel = pathComponents[4]
+		}
+	case len(pathComponents) == 6:
 		service = pathComponents[1]
 		userId = pathComponents[3]
 		postId = pathComponents[5]
-	} else {
+	case len(pathComponents) == 4:
 		service = pathComponents[1]
 		userId = pathComponents[3]
+	default:
+		log.Fatal("Error splitting host component:", pathComponents, len(pathComponents))
 	}
 
 	return
 }
 
-func parasData(data string) time.Time {
-	if len(data) != 8 {
-		log.Fatalf("invalid date %s", data)
-	}
-	year, err := strconv.Atoi(data[:4])
-	if err != nil {
-		log.Fatalf("invalid date %s", data)
-	}
-	month, err := strconv.Atoi(data[4:6])
+// siteDownloaderOptions the base url, ddos-guard cookie and browser headers of the site
+func siteDownloaderOptions(s string) []downloader.DownloadOption {
+	token, err := utils.GenerateToken(16)
 	if err != nil {
-		log.Fatalf("invalid date %s", data)
+		log.Fatalf("generate token failed: %s", err)
 	}
-	day, err := strconv.Atoi(data[6:])
-	if err != nil {
-		log.Fatalf("invalid date %s", data)
+	return []downloader.DownloadOption{
+		downloader.BaseURL(siteURL(s)),
+		downloader.WithCookie([]*http.Cookie{
+			{
+				Name:   "__ddg2",
+				Value:  token,
+				Path:   "/",
+				Domain: "." + siteHostname(s),
+			},
+		}),
+		downloader.WithHeader(downloader.Header{
+			"Host":                      siteHost(s),
+			"User-Agent":                downloader.UserAgent,
+			"Referer":                   siteURL(s) + "/",
+			"Accept":                    downloader.Accept,
+			"Accept-Language":           downloader.AcceptLanguage,
+			"Accept-Encoding":           downloader.AcceptEncoding,
+			"Sec-Ch-Ua":                 downloader.SecChUA,
+			"Sec-Ch-Ua-Mobile":          downloader.SecChUAMobile,
+			"Sec-Fetch-Dest":            downloader.SecFetchDest,
+			"Sec-Fetch-Mode":            downloader.SecFetchMode,
+			"Sec-Fetch-Site":            downloader.SecFetchSite,
+			"Sec-Fetch-User":            downloader.SecFetchUser,
+			"Upgrade-Insecure-Requests": downloader.UpgradeInsecureRequests,
+			"Connection":                "keep-alive",
+		}),
 	}
-	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 }
 
-func setFlag() {
-	if !passedFlags["link"] && config["link"] != nil {
-		link = config["link"].(string)
-	}
-	if !passedFlags["fav-site"] && config["fav-site"] != nil {
-		site = config["fav-site"].(string)
-	}
-	if !passedFlags["creator"] && config["creator"] != nil {
-		creator = config["creator"].(string)
-	}
-	if !passedFlags["banner"] && config["banner"] != nil {
-		banner = config["banner"].(bool)
-	}
-	if !passedFlags["overwrite"] && config["overwrite"] != nil {
-		overwrite = config["overwrite"].(bool)
-	}
-	if !passedFlags["first"] && config["first"] != nil {
-		first = config["first"].(int)
-	}
-	if !passedFlags["last"] && config["last"] != nil {
-		last = config["last"].(int)
-	}
-	if !passedFlags["date"] && config["date"] != nil {
-		date = config["date"].(int)
-	}
-	if !passedFlags["date-before"] && config["date-before"] != nil {
-		dateBefore = config["date-before"].(int)
-	}
-	if !passedFlags["date-after"] && config["date-after"] != nil {
-		dateAfter = config["date-after"].(int)
-	}
-	if !passedFlags["update"] && config["update"] != nil {
-		update = config["update"].(int)
-	}
-	if !passedFlags["update-before"] && config["update-before"] != nil {
-		updateBefore = config["update-before"].(int)
-	}
-	if !passedFlags["update-after"] && config["update-after"] != nil {
-		updateAfter = config["update-after"].(int)
-	}
-	if !passedFlags["extension-only"] && config["extension-only"] != nil {
-		extensionOnly = config["extension-only"].(string)
-	}
-	if !passedFlags["extension-exclude"] && config["extension-exclude"] != nil {
-		extensionExclude = config["extension-exclude"].(string)
-	}
-	if !passedFlags["output"] && config["output"] != nil {
-		output = config["output"].(string)
-	}
-	if !passedFlags["template"] && config["template"] != nil {
-		template = config["template"].(string)
+// siteURL return the origin of the site, set by --kemono-url or --coomer-url
+func siteURL(s string) string {
+	var base string
+	switch s {
+	case Kemono:
+		base = kemonoURL
+	case Coomer:
+		base = coomerURL
 	}
-	if !passedFlags["image-template"] && config["image-template"] != nil {
-		imageTemplate = config["image-template"].(string)
+	if base == "" {
+		base = fmt.Sprintf("https://%s.su", s)
 	}
-	if !passedFlags["audio-template"] && config["audio-template"] != nil {
-		audioTemplate = config["audio-template"].(string)
+	return strings.TrimRight(base, "/")
+}
+
+func parseSiteURL(s string) *url.URL {
+	u, err := url.Parse(siteURL(s))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		log.Fatalf("invalid %s url: %s", s, siteURL(s))
 	}
-	if !passedFlags["video-template"] && config["video-template"] != nil {
-		videoTemplate = config["video-template"].(string)
+	return u
+}
+
+// siteHost return the host (with port) of the site origin
+func siteHost(s string) string {
+	return parseSiteURL(s).Host
+}
+
+// siteHostname return the host (without port) of the site origin
+func siteHostname(s string) string {
+	return parseSiteURL(s).Hostname()
+}
+
+func parasData(data string) time.Time {
+	t, err := parseDate(data)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
-	if !passedFlags["archive-template"] && config["archive-template"] != nil {
-		archiveTemplate = config["archive-template"].(string)
+	return t
+}
+
+// postFilters the post filters of the options: first, last and the date ranges
+func postFilters(o CreatorOptions) []kemono.PostFilter {
+	var filters []kemono.PostFilter
+	if first := intValue(o.First); first != 0 {
+		filters = append(filters, kemono.NumbFilter(func(i int) bool {
+			return i <= first
+		}))
 	}
-	if !passedFlags["contrnt"] && config["content"] != nil {
-		content = config["content"].(bool)
+	if last := intValue(o.Last); last != 0 {
+		filters = append(filters, kemono.NumbFilter(func(i int) bool {
+			return i >= last
+		}))
 	}
-	if !passedFlags["async"] && config["async"] != nil {
-		async = config["async"].(bool)
+	if date := intValue(o.Date); date != 0 {
+		t := parasData(strconv.Itoa(date))
+		filters = append(filters, kemono.ReleaseDateFilter(t.Add(-1), t.Add(24*time.Hour-1)))
 	}
-	if !passedFlags["max-size"] && config["max-size"] != nil {
-		maxSize = config["max-size"].(string)
+	if dateBefore := intValue(o.DateBefore); dateBefore != 0 {
+		filters = append(filters, kemono.ReleaseDateBeforeFilter(parasData(strconv.Itoa(dateBefore))))
 	}
-	if !passedFlags["min-size"] && config["min-size"] != nil {
-		minSize = config["min-size"].(string)
+	if dateAfter := intValue(o.DateAfter); dateAfter != 0 {
+		filters = append(filters, kemono.ReleaseDateAfterFilter(parasData(strconv.Itoa(dateAfter))))
 	}
-	if !passedFlags["with-prefix-number"] && config["with-prefix-number"] != nil {
-		withPrefixNumber = config["with-prefix-number"].(bool)
+	if update := intValue(o.Update); update != 0 {
+		t := parasData(strconv.Itoa(update))
+		filters = append(filters, kemono.EditDateFilter(t.Add(-1), t.Add(24*time.Hour-1)))
 	}
-	if !passedFlags["name-rule-only-index"] && config["name-rule-only-index"] != nil {
-		nameRuleOnlyIndex = config["name-rule-only-index"].(bool)
+	if updateBefore := intValue(o.UpdateBefore); updateBefore != 0 {
+		filters = append(filters, kemono.EditDateBeforeFilter(parasData(strconv.Itoa(updateBefore))))
 	}
-	if !passedFlags["download-timeout"] && config["download-timeout"] != nil {
-		downloadTimeout = config["download-timeout"].(int)
+	if updateAfter := intValue(o.UpdateAfter); updateAfter != 0 {
+		filters = append(filters, kemono.EditDateAfterFilter(parasData(strconv.Itoa(updateAfter))))
 	}
+	return filters
+}
 
-	if !passedFlags["retry"] && config["retry"] != nil {
-		retry = config["retry"].(int)
-	}
-	if !passedFlags["retry-interval"] && config["retry-interval"] != nil {
-		// check if retry-interval is float64 or int
-		_, ok := config["retry-interval"].(float64)
-		if !ok {
-			retryInterval = float64(config["retry-interval"].(int))
-		} else {
-			retryInterval = config["retry-interval"].(float64)
+// parseExporters parse the comma separated export formats, index adds json for its sidecars
+func parseExporters(s string) ([]export.Exporter, error) {
+	var (
+		exporters []export.Exporter
+		formats   = make(map[string]bool)
+	)
+	for _, format := range strings.Split(s, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" || formats[format] {
+			continue
 		}
+		formats[format] = true
+		e, err := export.New(format)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
 	}
-	if !passedFlags["max-download-parallel"] && config["max-download-parallel"] != nil {
-		maxDownloadParallel = config["max-download-parallel"].(int)
+	if formats["index"] && !formats["json"] {
+		exporters = append([]export.Exporter{export.JSON()}, exporters...)
 	}
-	if !passedFlags["rate-limit"] && config["rate-limit"] != nil {
-		rateLimit = config["rate-limit"].(int)
+	return exporters, nil
+}
+
+// parseFetchers parse the comma separated file hosts of external links to download
+func parseFetchers(s string) ([]kemono.ExternalFetcher, error) {
+	var fetchers []kemono.ExternalFetcher
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	if proxy != "" {
+		downloader.AddProxy(proxy, client.Transport.(*http.Transport))
 	}
-	if !passedFlags["proxy"] && config["proxy"] != nil {
-		proxy = config["proxy"].(string)
+	for _, host := range strings.Split(s, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		f, err := external.New(host, client)
+		if err != nil {
+			return nil, err
+		}
+		fetchers = append(fetchers, f)
 	}
-	if !passedFlags["fav-creator"] && config["fav-creator"] != nil {
-		favoriteCreator = config["fav-creator"].(bool)
+	return fetchers, nil
+}
+
+// postCutoffs the cutoffs of the creator options, they stop the post list early: since-post, the date filters carry
+// their own
+func postCutoffs(o CreatorOptions) []kemono.PostCutoff {
+	var cutoffs []kemono.PostCutoff
+	if since := stringValue(o.SincePost); since != "" {
+		cutoffs = append(cutoffs, kemono.SincePostCutoff(since))
 	}
-	if !passedFlags["fav-post"] && config["fav-post"] != nil {
-		favoritePost = config["fav-post"].(bool)
+	return cutoffs
+}
+
+// attachmentFilters the attachment filters of the options: the extensions to include or exclude
+func attachmentFilters(o CreatorOptions) []kemono.AttachmentFilter {
+	var filters []kemono.AttachmentFilter
+	if only := stringValue(o.ExtensionOnly); only != "" {
+		filters = append(filters, kemono.ExtensionFilter(parseExtensions(only)...))
 	}
-	if !passedFlags["cookie-browser"] && config["cookie-browser"] != nil {
-		cookieBrowser = config["cookie-browser"].(string)
+	if exclude := stringValue(o.ExtensionExclude); exclude != "" {
+		filters = append(filters, kemono.ExtensionExcludeFilter(parseExtensions(exclude)...))
 	}
-	if !passedFlags["cookie"] && config["cookie"] != nil {
-		cookieFile = config["cookie"].(string)
+	return filters
+}
+
+// parseExtensions split a comma separated extension list, adding the missing dots
+func parseExtensions(s string) []string {
+	var extensions []string
+	for _, extension := range strings.Split(s, ",") {
+		extension = strings.TrimSpace(extension)
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		extensions = append(extensions, extension)
 	}
+	return extensions
 }
 
 func DirectoryName(p kemono.Post) string {
+	if p.Published.IsZero() {
+		// no date rather than 00010101
+		return fmt.Sprintf("[%s] %s", p.Id, p.Title)
+	}
 	return fmt.Sprintf("[%s] [%s] %s", p.Published.Format("20060102"), p.Id, p.Title)
 }
 
 func fetchFavoriteCreators(s string, cookie []*http.Cookie) []kemono.FavoriteCreator {
-	log.Printf("fetching favorite creators from %s.su", s)
+	log.Printf("fetching favorite creators from %s", siteURL(s))
+	favoriteCreators, err := favoriteCreators(s, cookie)
+	if err != nil {
+		log.Fatalf("Error getting favorites: %s", err)
+	}
+	return favoriteCreators
+}
+
+// favoriteCreators fetch the favorite creators of the account
+func favoriteCreators(s string, cookie []*http.Cookie) ([]kemono.FavoriteCreator, error) {
 	var client *http.Client
 	client = http.DefaultClient
 	if proxy != "" {
@@ -685,32 +809,32 @@ func fetchFavoriteCreators(s string, cookie []*http.Cookie) []kemono.FavoriteCre
 		downloader.AddProxy(proxy, client.Transport.(*http.Transport))
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s.su/api/v1/account/favorites?type=user", s), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/account/favorites?type=user", siteURL(s)), nil)
 	if err != nil {
-		log.Fatalf("Error creating request: %s", err)
+		return nil, fmt.Errorf("create request error: %w", err)
 	}
-	req.Header.Set("Host", fmt.Sprintf("%s.su", s))
+	req.Header.Set("Host", siteHost(s))
 	for _, v := range cookie {
 		req.AddCookie(v)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("Error getting favorites: %s", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		log.Fatalf("Error getting favorites: %d", resp.StatusCode)
+		return nil, fmt.Errorf("http status %d", resp.StatusCode)
 	}
 	var favoriteCreators []kemono.FavoriteCreator
 	err = json.NewDecoder(resp.Body).Decode(&favoriteCreators)
 	if err != nil {
-		log.Fatalf("Error decoding favorites: %s", err)
+		return nil, fmt.Errorf("decode favorites error: %w", err)
 	}
-	return favoriteCreators
+	return favoriteCreators, nil
 }
 
 func fetchFavoritePosts(s string, cookie []*http.Cookie) []kemono.PostRaw {
-	log.Printf("fetching favorite posts from %s.su", s)
+	log.Printf("fetching favorite posts from %s", siteURL(s))
 	var client *http.Client
 	client = http.DefaultClient
 	if proxy != "" {
@@ -722,11 +846,11 @@ func fetchFavoritePosts(s string, cookie []*http.Cookie) []kemono.PostRaw {
 		}
 		downloader.AddProxy(proxy, client.Transport.(*http.Transport))
 	}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s.su/api/v1/account/favorites?type=post", s), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/account/favorites?type=post", siteURL(s)), nil)
 	if err != nil {
 		log.Fatalf("Error creating request: %s", err)
 	}
-	req.Header.Set("Host", fmt.Sprintf("%s.su", s))
+	req.Header.Set("Host", siteHost(s))
 	for _, v := range cookie {
 		req.AddCookie(v)
 	}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"path/filepath"
 	"strings"
 	tmpl "text/template"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/utils"
 )
 
 const (
@@ -59,6 +64,15 @@ func ExecutePathTmpl(tmpl *tmpl.Template, config *PathConfig) string {
 	return path.String()
 }
 
+// Templates the path templates of the file types, an empty one falls back to Default
+type Templates struct {
+	Default string
+	Image   string
+	Video   string
+	Audio   string
+	Archive string
+}
+
 type TmplCache struct {
 	tmpl map[string]*tmpl.Template
 }
@@ -69,41 +83,25 @@ func NewTmplCache() *TmplCache {
 	}
 }
 
-func (t *TmplCache) init() {
-	// default template
-	tmpl, err := LoadPathTmpl(template, output)
-	if err != nil {
-		panic(err)
-	}
-	t.tmpl["default"] = tmpl
-	if imageTemplate != "" {
-		tmpl, err := LoadPathTmpl(imageTemplate, output)
-		if err != nil {
-			panic(err)
-		}
-		t.tmpl["image"] = tmpl
-	}
-	if videoTemplate != "" {
-		tmpl, err := LoadPathTmpl(videoTemplate, output)
-		if err != nil {
-			panic(err)
-		}
-		t.tmpl["video"] = tmpl
-	}
-	if audioTemplate != "" {
-		tmpl, err := LoadPathTmpl(audioTemplate, output)
-		if err != nil {
-			panic(err)
+// load parse the templates relative to output
+func (t *TmplCache) load(templates Templates, output string) error {
+	for typ, s := range map[string]string{
+		"default": templates.Default,
+		"image":   templates.Image,
+		"video":   templates.Video,
+		"audio":   templates.Audio,
+		"archive": templates.Archive,
+	} {
+		if s == "" {
+			continue
 		}
-		t.tmpl["audio"] = tmpl
-	}
-	if archiveTemplate != "" {
-		tmpl, err := LoadPathTmpl(archiveTemplate, output)
+		tmpl, err := LoadPathTmpl(s, output)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("load %s template error: %w", typ, err)
 		}
-		t.tmpl["archive"] = tmpl
+		t.tmpl[typ] = tmpl
 	}
+	return nil
 }
 
 func (t *TmplCache) GetTmpl(typ string) *tmpl.Template {
@@ -131,3 +129,64 @@ func getTyp(ext string) string {
 		return "default"
 	}
 }
+
+// SavePathFunc return the save path of an attachment
+type SavePathFunc func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string
+
+// newSavePath create the save path of the templates relative to output.
+// Without a default template, the file name rule flags select the built-in one
+func newSavePath(templates Templates, output string) (SavePathFunc, error) {
+	if templates.Default == "" {
+		if templates.Image != "" || templates.Video != "" || templates.Audio != "" || templates.Archive != "" {
+			log.Printf("to use image/video/audio/archive template, you must set template first")
+		}
+		defaultTemp, err := LoadPathTmpl(TmplDefault, output)
+		if err != nil {
+			return nil, err
+		}
+		t := defaultTemp
+		if nameRuleOnlyIndex {
+			t, err = LoadPathTmpl(TmplIndexNumber, output)
+		} else if withPrefixNumber {
+			t, err = LoadPathTmpl(TmplWithPrefixNumber, output)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+			pathConfig := newPathConfig(creator, post, i, attachment)
+			if ext := pathConfig.Extension; ext == ".zip" || ext == ".rar" || ext == ".7z" {
+				return ExecutePathTmpl(defaultTemp, pathConfig)
+			}
+			return ExecutePathTmpl(t, pathConfig)
+		}, nil
+	}
+
+	tmplCache := NewTmplCache()
+	if err := tmplCache.load(templates, output); err != nil {
+		return nil, err
+	}
+	return func(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) string {
+		pathConfig := newPathConfig(creator, post, i, attachment)
+		return tmplCache.Execute(getTyp(pathConfig.Extension), pathConfig)
+	}, nil
+}
+
+func newPathConfig(creator kemono.Creator, post kemono.Post, i int, attachment kemono.File) *PathConfig {
+	ext := filepath.Ext(attachment.Name)
+	filehash := filepath.Base(attachment.Path)[0 : len(filepath.Base(attachment.Path))-len(filepath.Ext(attachment.Path))]
+	filename := attachment.Name[0 : len(attachment.Name)-len(ext)]
+	// use Path extension if Name extension is empty
+	if ext == "" {
+		ext = filepath.Ext(attachment.Path)
+	}
+	return &PathConfig{
+		Service:   creator.Service,
+		Creator:   utils.ValidDirectoryName(creator.Name),
+		Post:      utils.ValidDirectoryName(DirectoryName(post)),
+		Index:     i,
+		Filename:  utils.ValidDirectoryName(filename),
+		Filehash:  utils.ValidDirectoryName(filehash),
+		Extension: ext,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/state"
+	"github.com/elvis972602/kemono-scraper/verify"
+)
+
+// runVerify the verify subcommand: kemono-scraper verify [options] DIR
+func runVerify(args []string) {
+	fs := newFlagSet("verify", "verify [options] DIR",
+		"Check a download tree: files named after their sha256, and the files recorded in the state, are hashed again.\n"+
+			"Corrupt, missing and orphaned partial files are listed, the exit code is 1 if a file is corrupt or missing.")
+	var (
+		statePath  string
+		redownload bool
+		jsonOutput bool
+		site       string
+	)
+	fs.StringVar(&statePath, "state", "", "existing download state database, read only, its files are checked too and missing ones are reported")
+	fs.BoolVar(&redownload, "redownload", false, "re-download the missing and corrupt files")
+	fs.BoolVar(&jsonOutput, "json", false, "print the report as json")
+	fs.StringVar(&site, "site", Kemono, "site to re-download from, kemono or coomer")
+	fs.StringVar(&proxy, "proxy", proxy, "proxy url, e.g. http://proxy.com:8080")
+	fs.StringVar(&kemonoURL, "kemono-url", kemonoURL, "origin of the kemono files")
+	fs.StringVar(&coomerURL, "coomer-url", coomerURL, "origin of the coomer files")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	checkSite(fs, site)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var options []verify.Option
+	if statePath != "" {
+		// verify only reads the state, a missing one is an error rather than an empty new database
+		db, err := state.OpenReadOnly(statePath)
+		if err != nil {
+			log.Fatalf("open state failed: %s", err)
+		}
+		defer db.Close()
+		options = append(options, verify.WithState(db))
+	}
+	report, err := verify.Verify(ctx, fs.Arg(0), options...)
+	if err != nil {
+		log.Fatalf("verify failed: %s", err)
+	}
+
+	if jsonOutput {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, r := range report.Results {
+			fmt.Printf("%-8s %s\n", r.Status, r.Path)
+		}
+		fmt.Printf("checked %d files: %d ok, %d corrupt, %d missing, %d orphaned partial files\n",
+			report.Checked, report.OK, report.Corrupt, report.Missing, report.Orphans)
+	}
+
+	bad := len(report.Bad())
+	if redownload && bad > 0 {
+		options := append(siteDownloaderOptions(site), downloader.SetLog(stderrLog{}))
+		if proxy != "" {
+			options = append(options, downloader.WithProxy(proxy))
+		}
+		d := downloader.NewDownloader(options...)
+		bad = 0
+		for _, r := range verify.Repair(ctx, d, report) {
+			if r.Status == kemono.FileFailed {
+				bad++
+				log.Printf("re-download %s failed: %s", r.SavePath, r.Error)
+			}
+		}
+	}
+	if bad > 0 {
+		os.Exit(1)
+	}
+}
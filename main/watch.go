@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/term"
+)
+
+// runWatch the watch subcommand, it takes the flags of download and keeps checking the creators for new posts
+func runWatch(args []string) {
+	watchMode = true
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: kemono-scraper watch [options]\n\n"+
+			"Check the creators or favorites every --watch-interval and download their new posts, until Ctrl-C.\n"+
+			"Edited posts are picked up only among the recent posts read to find the new ones.\n"+
+			"Takes the options of download.\n\nOptions:\n")
+		PrintDefaults()
+	}
+	runDownload(args)
+}
+
+// favoriteUsers watch the favorite creators of the account, fetched again before every check
+func favoriteUsers(s string, cookie []*http.Cookie) kemono.WatchOption {
+	return kemono.WatchUsers(func(ctx context.Context) ([]kemono.Creator, error) {
+		favorites, err := favoriteCreators(s, cookie)
+		if err != nil {
+			return nil, fmt.Errorf("fetch favorite creators error: %w", err)
+		}
+		users := make([]kemono.Creator, 0, len(favorites))
+		for _, f := range favorites {
+			users = append(users, kemono.NewCreator(f.Service, f.Id))
+		}
+		return users, nil
+	})
+}
+
+// watchSites watch the sites at the same time until ctx is done, and return the report of the last check of every
+// site. With --report the reports are written after every check
+func watchSites(ctx context.Context, terminal *term.Terminal, sites map[string]*kemono.Kemono) []*kemono.Report {
+	var (
+		mu sync.Mutex
+		// map[<site>]*Report of the last check
+		last = make(map[string]*kemono.Report)
+		wg   sync.WaitGroup
+	)
+	reports := func() []*kemono.Report {
+		var reports []*kemono.Report
+		for _, s := range []string{Kemono, Coomer} {
+			if r, ok := last[s]; ok {
+				reports = append(reports, r)
+			}
+		}
+		return reports
+	}
+	for s, k := range sites {
+		s, k := s, k
+		options := append([]kemono.WatchOption{
+			kemono.WatchInterval(watchInterval),
+			kemono.WatchJitter(watchJitter),
+			kemono.OnCheck(func(report *kemono.Report) {
+				mu.Lock()
+				defer mu.Unlock()
+				last[s] = report
+				if reportPath == "" {
+					return
+				}
+				if err := writeReports(reportPath, reports()); err != nil {
+					terminal.Printf("write report failed: %s", err)
+				}
+			}),
+		}, watchOptions[s]...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			terminal.Printf("Watching %s every %s", s, watchInterval)
+			if err := k.WatchContext(ctx, options...); err != nil {
+				terminal.Printf("%s watch failed: %s", s, err)
+			}
+		}()
+	}
+	wg.Wait()
+	terminal.Print("Watch stopped")
+	mu.Lock()
+	defer mu.Unlock()
+	return reports()
+}
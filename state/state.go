@@ -0,0 +1,227 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS files (
+	service       TEXT    NOT NULL,
+	user          TEXT    NOT NULL,
+	post          TEXT    NOT NULL,
+	hash          TEXT    NOT NULL,
+	remote        TEXT    NOT NULL,
+	path          TEXT    NOT NULL,
+	size          INTEGER NOT NULL,
+	downloaded_at INTEGER NOT NULL,
+	PRIMARY KEY (service, user, post, hash)
+);
+CREATE INDEX IF NOT EXISTS files_path ON files (path);
+CREATE TABLE IF NOT EXISTS posts (
+	service      TEXT    NOT NULL,
+	user         TEXT    NOT NULL,
+	post         TEXT    NOT NULL,
+	edited       INTEGER NOT NULL,
+	completed_at INTEGER NOT NULL,
+	PRIMARY KEY (service, user, post)
+);
+CREATE TABLE IF NOT EXISTS creators (
+	service   TEXT    NOT NULL,
+	user      TEXT    NOT NULL,
+	updated   INTEGER NOT NULL,
+	synced_at INTEGER NOT NULL,
+	PRIMARY KEY (service, user)
+);
+`
+
+// File a downloaded file
+type File struct {
+	Service string
+	User    string
+	Post    string
+	// Hash sha256 of the file, taken from the kemono path
+	Hash string
+	// Remote the kemono path of the file, e.g. /data/xx/xx/<hash>.png
+	Remote string
+	// Path where the file was saved
+	Path         string
+	Size         int64
+	DownloadedAt time.Time
+}
+
+// DB the download state, keyed by service/creator/post/file hash.
+// It implements kemono.State, kemono.CreatorState and downloader.State
+type DB struct {
+	db *sql.DB
+	// sqlite allows only one writer at a time
+	lock sync.Mutex
+}
+
+// Open open or create the state database at path
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, fmt.Errorf("open state database error: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create state database error: %w", err)
+	}
+	return &DB{db: db}, nil
+}
+
+// OpenReadOnly open the existing state database at path without creating or writing it, e.g. for a dry run.
+// The error wraps os.ErrNotExist if there is no database at path
+func OpenReadOnly(path string) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open state database error: %w", err)
+	}
+	mode := "mode=ro"
+	if _, err := os.Stat(path + "-wal"); os.IsNotExist(err) {
+		// no other connection, immutable keeps sqlite from creating the -wal and -shm files
+		mode += "&immutable=1"
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?%s&_busy_timeout=5000", path, mode))
+	if err != nil {
+		return nil, fmt.Errorf("open state database error: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open state database error: %w", err)
+	}
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// PostDone report whether all files of the post were downloaded, and the post was not edited since
+func (d *DB) PostDone(post kemono.Post) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var edited int64
+	err := d.db.QueryRow(`SELECT edited FROM posts WHERE service = ? AND user = ? AND post = ?`,
+		post.Service, post.User, post.Id).Scan(&edited)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("query post error: %w", err)
+	}
+	return edited == post.Edited.Unix(), nil
+}
+
+// MarkPost record that all files of the post were downloaded
+func (d *DB) MarkPost(post kemono.Post) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO posts (service, user, post, edited, completed_at) VALUES (?, ?, ?, ?, ?)`,
+		post.Service, post.User, post.Id, post.Edited.Unix(), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("record post error: %w", err)
+	}
+	return nil
+}
+
+// CreatorSynced return the update time of the creator when a watch last synced it
+func (d *DB) CreatorSynced(creator kemono.Creator) (updated time.Time, ok bool, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var nano int64
+	err = d.db.QueryRow(`SELECT updated FROM creators WHERE service = ? AND user = ?`,
+		creator.Service, creator.Id).Scan(&nano)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query creator error: %w", err)
+	}
+	return time.Unix(0, nano), true, nil
+}
+
+// MarkCreator record that a watch synced the creator at its update time
+func (d *DB) MarkCreator(creator kemono.Creator) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO creators (service, user, updated, synced_at) VALUES (?, ?, ?, ?)`,
+		creator.Service, creator.Id, creator.Updated.Time.UnixNano(), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("record creator error: %w", err)
+	}
+	return nil
+}
+
+// FileDone return where the file was saved, if it was downloaded before
+func (d *DB) FileDone(creator kemono.Creator, post kemono.Post, hash string) (path string, ok bool, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	err = d.db.QueryRow(`SELECT path FROM files WHERE service = ? AND user = ? AND post = ? AND hash = ?`,
+		creator.Service, creator.Id, post.Id, hash).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("query file error: %w", err)
+	}
+	return path, true, nil
+}
+
+// MarkFile record a downloaded file
+func (d *DB) MarkFile(creator kemono.Creator, post kemono.Post, file kemono.File, hash, path string, size int64) error {
+	return d.PutFile(File{
+		Service:      creator.Service,
+		User:         creator.Id,
+		Post:         post.Id,
+		Hash:         hash,
+		Remote:       file.Path,
+		Path:         path,
+		Size:         size,
+		DownloadedAt: time.Now(),
+	})
+}
+
+// PutFile insert or replace a file record
+func (d *DB) PutFile(f File) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO files (service, user, post, hash, remote, path, size, downloaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Service, f.User, f.Post, f.Hash, f.Remote, f.Path, f.Size, f.DownloadedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("record file error: %w", err)
+	}
+	return nil
+}
+
+// Files return every file record
+func (d *DB) Files() ([]File, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	rows, err := d.db.Query(`SELECT service, user, post, hash, remote, path, size, downloaded_at FROM files`)
+	if err != nil {
+		return nil, fmt.Errorf("query files error: %w", err)
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var (
+			f            File
+			downloadedAt int64
+		)
+		if err = rows.Scan(&f.Service, &f.User, &f.Post, &f.Hash, &f.Remote, &f.Path, &f.Size, &downloadedAt); err != nil {
+			return nil, fmt.Errorf("scan file error: %w", err)
+		}
+		f.DownloadedAt = time.Unix(downloadedAt, 0)
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+)
+
+func TestDB(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	creator := kemono.NewCreator("fanbox", "123")
+	post := kemono.Post{Id: "1", Service: "fanbox", User: "123", Edited: time.Unix(1000, 0)}
+	file := kemono.File{Name: "a.png", Path: "/data/aa/bb/aabb.png"}
+
+	if done, err := db.PostDone(post); err != nil || done {
+		t.Fatalf("unexpected post state: %v %v", done, err)
+	}
+	if _, ok, err := db.FileDone(creator, post, "aabb"); err != nil || ok {
+		t.Fatalf("unexpected file state: %v %v", ok, err)
+	}
+
+	if err := db.MarkFile(creator, post, file, "aabb", "/tmp/a.png", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkPost(post); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, ok, err := db.FileDone(creator, post, "aabb"); err != nil || !ok || path != "/tmp/a.png" {
+		t.Fatalf("unexpected file state: %s %v %v", path, ok, err)
+	}
+	if done, err := db.PostDone(post); err != nil || !done {
+		t.Fatalf("unexpected post state: %v %v", done, err)
+	}
+
+	// an edited post should be downloaded again
+	post.Edited = time.Unix(2000, 0)
+	if done, err := db.PostDone(post); err != nil || done {
+		t.Fatalf("edited post should not be done: %v %v", done, err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	if _, err := OpenReadOnly(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the database should not be created: %v", err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := kemono.Post{Id: "1", Service: "fanbox", User: "123"}
+	if err := db.MarkPost(post); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if done, err := ro.PostDone(post); err != nil || !done {
+		t.Fatalf("unexpected post state: %v %v", done, err)
+	}
+	if err := ro.MarkPost(kemono.Post{Id: "2", Service: "fanbox", User: "123"}); err == nil {
+		t.Fatalf("a read only database should not be written")
+	}
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("the wal file should not be created: %v", err)
+	}
+}
+
+func TestDB_Creators(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	creator := kemono.NewCreator("fanbox", "123")
+	creator.Updated = kemono.Timestamp{Time: time.Unix(1000, 500)}
+	if _, ok, err := db.CreatorSynced(creator); err != nil || ok {
+		t.Fatalf("unexpected creator state: %v %v", ok, err)
+	}
+	if err := db.MarkCreator(creator); err != nil {
+		t.Fatal(err)
+	}
+	if updated, ok, err := db.CreatorSynced(creator); err != nil || !ok || !updated.Equal(creator.Updated.Time) {
+		t.Fatalf("unexpected creator state: %s %v %v", updated, ok, err)
+	}
+}
@@ -81,6 +81,11 @@ func NewTerminal(w io.Writer, errWriter io.Writer, disableStatus bool) *Terminal
 	return t
 }
 
+// Done returns a channel that is closed when Run returns
+func (t *Terminal) Done() <-chan struct{} {
+	return t.closed
+}
+
 func (t *Terminal) Run(ctx context.Context) {
 	defer close(t.closed)
 	if t.updateStatus {
@@ -98,7 +103,13 @@ func (t *Terminal) run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-
+			// leave the cursor below the status lines and flush what is buffered
+			if lastLineCount > 0 {
+				_, _ = t.wr.WriteString("\n")
+			}
+			if err := t.wr.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "flush failed: %v\n", err)
+			}
 			return
 
 		case msg := <-t.msg:
@@ -175,6 +186,9 @@ func (t *Terminal) runWithoutStatus(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
+			if err := t.wr.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "flush failed: %v\n", err)
+			}
 			return
 		case msg := <-t.msg:
 			var flush func() error
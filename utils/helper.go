@@ -10,7 +10,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 )
 
 func SplitHash(str string) (string, error) {
@@ -78,57 +77,6 @@ func ValidDirectoryName(name string) string {
 	return s
 }
 
-type semaphore chan struct{}
-
-func newSemaphore(n int) semaphore {
-	return make(semaphore, n)
-}
-
-func (s semaphore) acquire() {
-	s <- struct{}{}
-}
-
-func (s semaphore) release() {
-	<-s
-}
-
-type RateLimiter struct {
-	limit     int
-	semaphore semaphore
-}
-
-func NewRateLimiter(tokenPreSecond int) *RateLimiter {
-	r := &RateLimiter{
-		limit:     tokenPreSecond,
-		semaphore: newSemaphore(tokenPreSecond),
-	}
-	r.Timing()
-	return r
-}
-
-// Timing add token into semaphore
-func (r *RateLimiter) Timing() {
-	t := time.NewTicker(time.Second)
-	// full semaphore
-	for i := 0; i < r.limit; i++ {
-		r.semaphore.acquire()
-	}
-	go func() {
-		for {
-			select {
-			case <-t.C:
-				for i := 0; i < r.limit; i++ {
-					r.semaphore.acquire()
-				}
-			}
-		}
-	}()
-}
-
-func (r *RateLimiter) Token() {
-	r.semaphore.release()
-}
-
 func GenerateToken(size int) (string, error) {
 	data := make([]byte, size)
 	_, err := rand.Read(data)
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// ErrLimiterStopped is returned by Wait after Stop
+var ErrLimiterStopped = errors.New("rate limiter stopped")
+
+// RateLimiter a token bucket, refilled with rate tokens per second, holding at most burst tokens
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// paused until, set by Backoff
+	until   time.Time
+	backoff time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRateLimiter create a limiter of rate requests per second (may be fractional, e.g. 0.5),
+// allowing burst requests at once. A rate <= 0 means no limit
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		stop:   make(chan struct{}),
+	}
+}
+
+// reserve take a token, or return how long to wait for the next one
+func (r *RateLimiter) reserve(now time.Time) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if now.Before(r.until) {
+		return r.until.Sub(now)
+	}
+	if r.rate <= 0 {
+		return 0
+	}
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+}
+
+// Wait block until a token is available, ctx is done or the limiter is stopped
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		select {
+		case <-r.stop:
+			return ErrLimiterStopped
+		default:
+		}
+		wait := r.reserve(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-r.stop:
+			t.Stop()
+			return ErrLimiterStopped
+		case <-t.C:
+		}
+	}
+}
+
+// Token block until a token is available
+func (r *RateLimiter) Token() {
+	_ = r.Wait(context.Background())
+}
+
+// Backoff pause the limiter after a 429 response, for an exponentially growing delay (1s up to 1m)
+func (r *RateLimiter) Backoff() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.backoff == 0 {
+		r.backoff = minBackoff
+	} else if r.backoff < maxBackoff {
+		r.backoff *= 2
+		if r.backoff > maxBackoff {
+			r.backoff = maxBackoff
+		}
+	}
+	r.pause(r.backoff)
+}
+
+// Pause pause the limiter for d, e.g. as long as a Retry-After header asks
+func (r *RateLimiter) Pause(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pause(d)
+}
+
+func (r *RateLimiter) pause(d time.Duration) {
+	if until := time.Now().Add(d); until.After(r.until) {
+		r.until = until
+	}
+	// start with an empty bucket after the pause
+	r.tokens = 0
+	r.last = r.until
+}
+
+// Recover reset the backoff after a successful response
+func (r *RateLimiter) Recover() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.backoff = 0
+}
+
+// Stop release every waiting caller, later Wait calls return ErrLimiterStopped
+func (r *RateLimiter) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// HostLimiter a RateLimiter per host, with the same rate and burst
+type HostLimiter struct {
+	rate     float64
+	burst    int
+	lock     sync.Mutex
+	limiters map[string]*RateLimiter
+	stopped  bool
+}
+
+func NewHostLimiter(rate float64, burst int) *HostLimiter {
+	return &HostLimiter{
+		rate:     rate,
+		burst:    burst,
+		limiters: make(map[string]*RateLimiter),
+	}
+}
+
+// Get return the limiter of host
+func (h *HostLimiter) Get(host string) *RateLimiter {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = NewRateLimiter(h.rate, h.burst)
+		if h.stopped {
+			l.Stop()
+		}
+		h.limiters[host] = l
+	}
+	return l
+}
+
+// Wait block until a token of host is available
+func (h *HostLimiter) Wait(ctx context.Context, host string) error {
+	return h.Get(host).Wait(ctx)
+}
+
+// Stop stop the limiters of every host
+func (h *HostLimiter) Stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.stopped = true
+	for _, l := range h.limiters {
+		l.Stop()
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	r := NewRateLimiter(20, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := r.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2 tokens at once, then 2 more at 20/s
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("limiter too fast: %s", elapsed)
+	}
+}
+
+func TestRateLimiter_Fractional(t *testing.T) {
+	r := NewRateLimiter(0.5, 1)
+	if err := r.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// the next token comes after 2s
+	if err := r.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRateLimiter_BackoffAndStop(t *testing.T) {
+	r := NewRateLimiter(100, 1)
+	r.Pause(time.Hour)
+	done := make(chan error)
+	go func() {
+		done <- r.Wait(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Stop()
+	select {
+	case err := <-done:
+		if err != ErrLimiterStopped {
+			t.Errorf("expected ErrLimiterStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not release Wait")
+	}
+}
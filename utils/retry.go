@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attempt a failed attempt of a request
+type Attempt struct {
+	// StatusCode the http status of the response, 0 if the request failed without a response
+	StatusCode int
+	// Header the header of the response, nil if there is no response
+	Header http.Header
+	Err    error
+	// ConnFailures and StatusFailures count the failures of the request so far, including this one
+	ConnFailures   int
+	StatusFailures int
+}
+
+// RetryPolicy decide whether a failed request is retried, and how long to wait before
+type RetryPolicy interface {
+	Retry(a Attempt) (delay time.Duration, ok bool)
+}
+
+// ExponentialBackoff retry with delays growing from Base to Max, randomized by Jitter.
+// A Retry-After header longer than the delay is honored. 403, 404 and 410 are never retried
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+	// Jitter randomize each delay by up to this fraction of it, between 0 and 1
+	Jitter float64
+	// ConnRetries retries after connection errors, StatusRetries retries after http errors
+	ConnRetries   int
+	StatusRetries int
+}
+
+func (b ExponentialBackoff) Retry(a Attempt) (time.Duration, bool) {
+	switch a.StatusCode {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return 0, false
+	}
+	if a.StatusCode == 0 && a.ConnFailures > b.ConnRetries {
+		return 0, false
+	}
+	if a.StatusCode != 0 && a.StatusFailures > b.StatusRetries {
+		return 0, false
+	}
+
+	failures := a.ConnFailures + a.StatusFailures
+	delay := time.Duration(float64(b.Base) * math.Pow(2, float64(failures-1)))
+	if b.Max > 0 && (delay > b.Max || delay < 0) {
+		delay = b.Max
+	}
+	if b.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(delay))
+	}
+	if retryAfter, ok := ParseRetryAfter(a.Header.Get("Retry-After"), time.Now()); ok && retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay, true
+}
+
+// ParseRetryAfter parse a Retry-After header, either in seconds or an http date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Sun, 01 Jan 2023 00:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 31 Dec 2022 23:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Second, Max: 5 * time.Second, ConnRetries: 1, StatusRetries: 4}
+
+	if _, ok := b.Retry(Attempt{StatusCode: http.StatusNotFound, StatusFailures: 1}); ok {
+		t.Error("404 should not be retried")
+	}
+	if _, ok := b.Retry(Attempt{ConnFailures: 2}); ok {
+		t.Error("connection retries should be used up")
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, ok := b.Retry(Attempt{StatusCode: http.StatusServiceUnavailable, StatusFailures: i + 1})
+		if !ok || delay != want {
+			t.Errorf("failure %d: got %s, %v, want %s", i+1, delay, ok, want)
+		}
+	}
+	if _, ok := b.Retry(Attempt{StatusCode: http.StatusServiceUnavailable, StatusFailures: 5}); ok {
+		t.Error("status retries should be used up")
+	}
+
+	header := http.Header{"Retry-After": []string{"30"}}
+	if delay, _ := b.Retry(Attempt{StatusCode: http.StatusTooManyRequests, Header: header, StatusFailures: 1}); delay != 30*time.Second {
+		t.Errorf("Retry-After should be honored, got %s", delay)
+	}
+}
@@ -0,0 +1,288 @@
+// Package verify audits a download tree: it recomputes the sha256 of every file whose name
+// or state record holds a kemono hash, and finds missing, corrupt and orphaned partial files.
+package verify
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/state"
+	"github.com/elvis972602/kemono-scraper/utils"
+)
+
+type Status string
+
+const (
+	// OK the file matches its hash
+	OK Status = "ok"
+	// Missing the file is recorded in the state, but not on disk
+	Missing Status = "missing"
+	// Corrupt the file does not match its hash
+	Corrupt Status = "corrupt"
+	// Orphan a partial .tmp file left by an interrupted download
+	Orphan Status = "orphan"
+)
+
+// hashName a sha256 at the end of a file name, e.g. <hash>.png or 1-<hash>.png
+var hashName = regexp.MustCompile(`(?i)([0-9a-f]{64})$`)
+
+// Records the file records of a download state
+type Records interface {
+	Files() ([]state.File, error)
+}
+
+// Result the outcome of a file
+type Result struct {
+	Path   string `json:"path"`
+	Status Status `json:"status"`
+	// Hash the expected sha256, Actual the computed one
+	Hash   string `json:"hash,omitempty"`
+	Actual string `json:"actual,omitempty"`
+	// Remote the kemono path of the file, empty if unknown
+	Remote  string `json:"remote,omitempty"`
+	Service string `json:"service,omitempty"`
+	User    string `json:"user,omitempty"`
+	Post    string `json:"post,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report the outcome of a verification, Results holds the files that are not OK
+type Report struct {
+	Checked int      `json:"checked"`
+	OK      int      `json:"ok"`
+	Missing int      `json:"missing"`
+	Corrupt int      `json:"corrupt"`
+	Orphans int      `json:"orphans"`
+	Results []Result `json:"results"`
+}
+
+func (r *Report) add(res Result) {
+	switch res.Status {
+	case OK:
+		r.OK++
+	case Missing:
+		r.Missing++
+	case Corrupt:
+		r.Corrupt++
+	case Orphan:
+		r.Orphans++
+	}
+	if res.Status != Orphan {
+		r.Checked++
+	}
+	if res.Status != OK {
+		r.Results = append(r.Results, res)
+	}
+}
+
+// Bad return the missing and corrupt files
+func (r *Report) Bad() []Result {
+	var bad []Result
+	for _, res := range r.Results {
+		if res.Status == Missing || res.Status == Corrupt {
+			bad = append(bad, res)
+		}
+	}
+	return bad
+}
+
+type Option func(*verifier)
+
+type verifier struct {
+	records Records
+	// progress called after each file
+	progress func(Result)
+}
+
+// WithState check the files recorded in the state too, and report the missing ones
+func WithState(records Records) Option {
+	return func(v *verifier) {
+		v.records = records
+	}
+}
+
+// WithProgress call f after each file
+func WithProgress(f func(Result)) Option {
+	return func(v *verifier) {
+		v.progress = f
+	}
+}
+
+// Verify walk root and check every file against its hash, stops when ctx is done
+func Verify(ctx context.Context, root string, options ...Option) (*Report, error) {
+	v := &verifier{progress: func(Result) {}}
+	for _, option := range options {
+		option(v)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("verify path error: %w", err)
+	}
+
+	// records of the state under root, map[abs path]record
+	records := make(map[string]state.File)
+	if v.records != nil {
+		files, err := v.records.Files()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			path, err := filepath.Abs(f.Path)
+			if err != nil || !within(root, path) {
+				continue
+			}
+			records[path] = f
+		}
+	}
+
+	report := &Report{}
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := kemono.Cancelled(ctx); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		seen[path] = true
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".tmp.validator") {
+			v.done(report, Result{Path: path, Status: Orphan})
+			return nil
+		}
+
+		res := Result{Path: path}
+		if f, ok := records[path]; ok {
+			res.Hash, res.Remote, res.Service, res.User, res.Post = f.Hash, f.Remote, f.Service, f.User, f.Post
+		} else if hash := nameHash(name); hash != "" {
+			res.Hash = hash
+			res.Remote = remotePath(hash, filepath.Ext(name))
+		} else {
+			// no hash to check against
+			return nil
+		}
+		v.done(report, check(res))
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for path, f := range records {
+		if seen[path] {
+			continue
+		}
+		res := Result{Path: path, Hash: f.Hash, Remote: f.Remote, Service: f.Service, User: f.User, Post: f.Post}
+		if _, err := os.Stat(path); err == nil {
+			// outside the walk, e.g. a symlink target
+			v.done(report, check(res))
+			continue
+		}
+		res.Status = Missing
+		v.done(report, res)
+	}
+	return report, nil
+}
+
+func (v *verifier) done(report *Report, res Result) {
+	report.add(res)
+	v.progress(res)
+}
+
+// check recompute the hash of the file
+func check(res Result) Result {
+	f, err := os.Open(res.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			res.Status = Missing
+			return res
+		}
+		res.Status = Corrupt
+		res.Error = err.Error()
+		return res
+	}
+	defer f.Close()
+	h, err := utils.Hash(f)
+	if err != nil {
+		res.Status = Corrupt
+		res.Error = err.Error()
+		return res
+	}
+	res.Actual = hex.EncodeToString(h)
+	if strings.EqualFold(res.Actual, res.Hash) {
+		res.Status = OK
+	} else {
+		res.Status = Corrupt
+	}
+	return res
+}
+
+// nameHash return the sha256 in the file name, empty if there is none
+func nameHash(name string) string {
+	m := hashName.FindStringSubmatch(strings.TrimSuffix(name, filepath.Ext(name)))
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// remotePath the kemono path of a file: /<hash[0:2]>/<hash[2:4]>/<hash><ext>
+func remotePath(hash, ext string) string {
+	return fmt.Sprintf("/%s/%s/%s%s", hash[0:2], hash[2:4], hash, ext)
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Repair re-download the missing and corrupt files of the report to their paths.
+// Files without a known kemono path are reported as failed
+func Repair(ctx context.Context, d kemono.Downloader, report *Report) []kemono.FileResult {
+	type postKey struct {
+		service, user, post string
+	}
+	var (
+		order   []postKey
+		files   = make(map[postKey][]kemono.FileWithIndex)
+		results []kemono.FileResult
+	)
+	for i, res := range report.Bad() {
+		file := kemono.File{Name: filepath.Base(res.Path), Path: res.Remote}.Index(i)
+		file.Target = res.Path
+		if res.Remote == "" {
+			results = append(results, kemono.NewFileResult(file, res.Path, kemono.FileFailed).Failed(errors.New("unknown kemono path")))
+			continue
+		}
+		key := postKey{res.Service, res.User, res.Post}
+		if _, ok := files[key]; !ok {
+			order = append(order, key)
+		}
+		files[key] = append(files[key], file)
+	}
+
+	for _, key := range order {
+		ch := make(chan kemono.FileWithIndex, len(files[key]))
+		for _, f := range files[key] {
+			ch <- f
+		}
+		close(ch)
+		creator := kemono.NewCreator(key.service, key.user)
+		post := kemono.Post{Id: key.post, Service: key.service, User: key.user}
+		for r := range d.DownloadContext(ctx, ch, creator, post) {
+			results = append(results, r)
+		}
+	}
+	return results
+}
@@ -0,0 +1,89 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elvis972602/kemono-scraper/downloader"
+	"github.com/elvis972602/kemono-scraper/kemono"
+	"github.com/elvis972602/kemono-scraper/kemono/kemonotest"
+	"github.com/elvis972602/kemono-scraper/state"
+)
+
+type nopLog struct{}
+
+func (nopLog) Printf(format string, v ...interface{}) {}
+func (nopLog) Print(s string)                         {}
+func (nopLog) SetStatus(s []string)                   {}
+
+type records []state.File
+
+func (r records) Files() ([]state.File, error) {
+	return r, nil
+}
+
+func hashOf(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	srv := kemonotest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	good := []byte("good file")
+	corrupt := []byte("corrupt file")
+	missing := []byte("missing file")
+	goodFile := srv.AddFile("good.png", good)
+	corruptFile := srv.AddFile("corrupt.png", corrupt)
+	missingFile := srv.AddFile("missing.png", missing)
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, "post", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write(filepath.Base(goodFile.Path), good)
+	// named after its hash, with a prefix number
+	corruptPath := write("1-"+filepath.Base(corruptFile.Path), []byte("flipped bits"))
+	write("partial.png.tmp", []byte("part"))
+	write("readme.txt", []byte("no hash, not checked"))
+	missingPath := filepath.Join(dir, "post", "missing.png")
+
+	r := records{{Service: "fanbox", User: "1", Post: "1", Hash: hashOf(missing), Remote: missingFile.Path, Path: missingPath}}
+	report, err := Verify(context.Background(), dir, WithState(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || report.OK != 1 || report.Corrupt != 1 || report.Missing != 1 || report.Orphans != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	d := downloader.NewDownloader(
+		downloader.BaseURL(srv.URL),
+		downloader.RateLimit(100),
+		downloader.APIRateLimit(100),
+		downloader.SetLog(nopLog{}),
+	)
+	for _, res := range Repair(context.Background(), d, report) {
+		if res.Status != kemono.FileDownloaded {
+			t.Errorf("repair %s failed: %s", res.SavePath, res.Error)
+		}
+	}
+	for path, data := range map[string][]byte{corruptPath: corrupt, missingPath: missing} {
+		got, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s not repaired: %v", path, err)
+		}
+	}
+}